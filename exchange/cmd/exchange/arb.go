@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ArbPath describes a 3-symbol triangular cycle to scan, e.g.
+// BTCUSDT -> ETHBTC -> ETHUSDT. Dirs[i] is true when hop i is quoted in the
+// asset being bought (so the forward leg crosses the ask); false when it
+// crosses the bid.
+type ArbPath struct {
+	Symbols        [3]string `json:"symbols"`
+	Dirs           [3]bool   `json:"dirs"`
+	FeeRate        float64   `json:"fee_rate"`         // per-hop fee, e.g. 0.001 for 10bps
+	MinSpreadRatio float64   `json:"min_spread_ratio"` // e.g. 1.001
+}
+
+// ArbOpportunity is a detected (and possibly executed) triangular arb.
+type ArbOpportunity struct {
+	Symbols       [3]string `json:"symbols"`
+	ForwardRatio  float64   `json:"forward_ratio"`
+	BackwardRatio float64   `json:"backward_ratio"`
+	Executed      bool      `json:"executed"`
+	RealizedPnL   float64   `json:"realized_pnl"`
+	Timestamp     int64     `json:"timestamp"`
+}
+
+var arbState = struct {
+	mu            sync.RWMutex
+	paths         []ArbPath
+	opportunities []ArbOpportunity
+	balanceLimits map[string]float64 // per-symbol order quantity for each arb leg; not checked against any balance
+}{
+	balanceLimits: make(map[string]float64),
+}
+
+func handleArbPaths(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		arbState.mu.RLock()
+		defer arbState.mu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(arbState.paths)
+		return
+	}
+
+	var path ArbPath
+	if err := json.NewDecoder(r.Body).Decode(&path); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if path.MinSpreadRatio <= 0 {
+		path.MinSpreadRatio = 1.001
+	}
+
+	arbState.mu.Lock()
+	arbState.paths = append(arbState.paths, path)
+	arbState.mu.Unlock()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(path)
+}
+
+func handleArbOpportunities(w http.ResponseWriter, r *http.Request) {
+	arbState.mu.RLock()
+	defer arbState.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(arbState.opportunities)
+}
+
+// startArbitrageScanner continuously evaluates configured triangular paths
+// and executes any that clear minSpreadRatio after fees.
+func startArbitrageScanner() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		arbState.mu.RLock()
+		paths := make([]ArbPath, len(arbState.paths))
+		copy(paths, arbState.paths)
+		arbState.mu.RUnlock()
+
+		for _, path := range paths {
+			scanArbPath(path)
+		}
+	}
+}
+
+// hopBestPrices returns the best bid and ask for a symbol's market, or false
+// if the book has no quotes on either side.
+func hopBestPrices(symbol string) (bid, ask float64, ok bool) {
+	market := exchange.getMarket(symbol)
+	if market == nil {
+		return 0, 0, false
+	}
+	snap := market.getOrderBookSnapshot()
+	if len(snap.Bids) == 0 || len(snap.Asks) == 0 {
+		return 0, 0, false
+	}
+	bid = snap.Bids[0].Price
+	ask = snap.Asks[0].Price
+	for _, b := range snap.Bids {
+		if b.Price > bid {
+			bid = b.Price
+		}
+	}
+	for _, a := range snap.Asks {
+		if a.Price < ask {
+			ask = a.Price
+		}
+	}
+	return bid, ask, true
+}
+
+// tripRatio computes the product ratio for one direction around the cycle.
+// forward=true walks the path as configured; forward=false walks it in
+// reverse with directions inverted.
+func tripRatio(path ArbPath, forward bool) (float64, bool) {
+	ratio := 1.0
+	for i := 0; i < 3; i++ {
+		idx := i
+		dir := path.Dirs[i]
+		if !forward {
+			idx = 2 - i
+			dir = !path.Dirs[idx]
+		}
+
+		bid, ask, ok := hopBestPrices(path.Symbols[idx])
+		if !ok {
+			return 0, false
+		}
+
+		if dir {
+			ratio *= 1 / ask
+		} else {
+			ratio *= bid
+		}
+		ratio *= 1 - path.FeeRate
+	}
+	return ratio, true
+}
+
+func scanArbPath(path ArbPath) {
+	forward, ok1 := tripRatio(path, true)
+	backward, ok2 := tripRatio(path, false)
+	if !ok1 || !ok2 {
+		return
+	}
+
+	opp := ArbOpportunity{
+		Symbols:       path.Symbols,
+		ForwardRatio:  forward,
+		BackwardRatio: backward,
+		Timestamp:     time.Now().Unix(),
+	}
+
+	ratio := forward
+	reverse := false
+	if backward > forward {
+		ratio = backward
+		reverse = true
+	}
+
+	if ratio >= path.MinSpreadRatio {
+		pnl, executed := executeArbCycle(path, reverse)
+		opp.Executed = executed
+		opp.RealizedPnL = pnl
+	}
+
+	arbState.mu.Lock()
+	arbState.opportunities = append(arbState.opportunities, opp)
+	if len(arbState.opportunities) > 500 {
+		arbState.opportunities = arbState.opportunities[len(arbState.opportunities)-500:]
+	}
+	arbState.mu.Unlock()
+}
+
+// executeArbCycle synthesizes three internal market orders in sequence
+// around the path, each sized by balanceLimits for its symbol (1 when unset).
+// No leg is checked against an available balance; instead, a leg that does
+// not fully fill aborts the cycle and unwinds the legs already executed, so
+// a failed leg doesn't leave a naked position.
+func executeArbCycle(path ArbPath, reverse bool) (float64, bool) {
+	order := [3]int{0, 1, 2}
+	if reverse {
+		order = [3]int{2, 1, 0}
+	}
+
+	executedLegs := make([]*Order, 0, 3)
+	pnl := 0.0
+
+	for _, idx := range order {
+		symbol := path.Symbols[idx]
+		dir := path.Dirs[idx]
+		if reverse {
+			dir = !dir
+		}
+
+		arbState.mu.RLock()
+		limit := arbState.balanceLimits[symbol]
+		arbState.mu.RUnlock()
+		if limit <= 0 {
+			limit = 1 // default tiny clip size when no limit configured
+		}
+
+		side := "SELL"
+		if dir {
+			side = "BUY"
+		}
+
+		market := exchange.getOrCreateMarket(symbol)
+		leg := &Order{
+			ID:        time.Now().Format("20060102150405.000000000") + "_arb_" + symbol,
+			Symbol:    symbol,
+			Side:      side,
+			OrderType: "MARKET",
+			Qty:       int(limit),
+			Status:    "NEW",
+			CreatedAt: time.Now().Unix(),
+		}
+		if leg.Qty <= 0 {
+			leg.Qty = 1
+		}
+
+		processMarketOrder(market, leg)
+		// processMarketOrder always marks a market order FILLED, even when the
+		// book couldn't actually cover it, so Status can't distinguish a real
+		// fill from a naked leg - check the quantity it actually matched
+		// instead, the same way bracket.go's OCO legs do.
+		if leg.FilledQty < leg.Qty {
+			log.Printf("⚠️ arb leg only filled %d/%d on %s, unwinding %d prior leg(s) to avoid a naked position", leg.FilledQty, leg.Qty, symbol, len(executedLegs))
+			for _, executed := range executedLegs {
+				pnl += flattenArbLeg(executed)
+			}
+			return pnl, len(executedLegs) > 0
+		}
+
+		executedLegs = append(executedLegs, leg)
+		if leg.AvgPrice != nil {
+			if side == "BUY" {
+				pnl -= *leg.AvgPrice * float64(leg.FilledQty)
+			} else {
+				pnl += *leg.AvgPrice * float64(leg.FilledQty)
+			}
+		}
+	}
+
+	log.Printf("♻️ arb cycle executed across %v, realized pnl=%.4f", path.Symbols, pnl)
+	return pnl, true
+}
+
+// flattenArbLeg unwinds a previously-executed arb leg by sending an
+// offsetting market order for the quantity it actually filled, so aborting a
+// cycle partway through doesn't leave a naked position on an earlier hop. It
+// returns that unwind's own pnl contribution, to be folded into the cycle's
+// realized pnl alongside whatever the aborted cycle already made or lost.
+func flattenArbLeg(leg *Order) float64 {
+	if leg.FilledQty <= 0 {
+		return 0
+	}
+
+	unwindSide := "SELL"
+	if leg.Side == "SELL" {
+		unwindSide = "BUY"
+	}
+
+	market := exchange.getOrCreateMarket(leg.Symbol)
+	unwind := &Order{
+		ID:        time.Now().Format("20060102150405.000000000") + "_arbunwind_" + leg.Symbol,
+		Symbol:    leg.Symbol,
+		Side:      unwindSide,
+		OrderType: "MARKET",
+		Qty:       leg.FilledQty,
+		Status:    "NEW",
+		CreatedAt: time.Now().Unix(),
+	}
+	processMarketOrder(market, unwind)
+	log.Printf("↩️ arb: unwound %d unit(s) on %s to flatten a naked leg after the cycle aborted", leg.FilledQty, leg.Symbol)
+
+	if unwind.AvgPrice == nil {
+		return 0
+	}
+	if unwindSide == "BUY" {
+		return -*unwind.AvgPrice * float64(unwind.FilledQty)
+	}
+	return *unwind.AvgPrice * float64(unwind.FilledQty)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+// PriceLevel is a single price point on one side of the book: a FIFO queue
+// of resting orders in strict arrival order.
+type PriceLevel struct {
+	Price  float64
+	Orders []*Order
+}
+
+// maxPriceHeap orders bid prices highest-first.
+type maxPriceHeap []float64
+
+func (h maxPriceHeap) Len() int            { return len(h) }
+func (h maxPriceHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h maxPriceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *maxPriceHeap) Push(x interface{}) { *h = append(*h, x.(float64)) }
+func (h *maxPriceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	price := old[n-1]
+	*h = old[:n-1]
+	return price
+}
+
+// minPriceHeap orders ask prices lowest-first.
+type minPriceHeap []float64
+
+func (h minPriceHeap) Len() int            { return len(h) }
+func (h minPriceHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h minPriceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *minPriceHeap) Push(x interface{}) { *h = append(*h, x.(float64)) }
+func (h *minPriceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	price := old[n-1]
+	*h = old[:n-1]
+	return price
+}
+
+// insertBid adds a resting bid to its price level, creating the level (and
+// pushing it onto the heap) if this is the first order at that price.
+// Callers must hold market.mu.
+func insertBid(market *Market, order *Order) {
+	price := *order.LimitPrice
+	level, ok := market.BidLevels[price]
+	if !ok {
+		level = &PriceLevel{Price: price}
+		market.BidLevels[price] = level
+		heap.Push(market.bidHeap, price)
+	}
+	level.Orders = append(level.Orders, order)
+}
+
+// insertAsk is the ask-side mirror of insertBid.
+func insertAsk(market *Market, order *Order) {
+	price := *order.LimitPrice
+	level, ok := market.AskLevels[price]
+	if !ok {
+		level = &PriceLevel{Price: price}
+		market.AskLevels[price] = level
+		heap.Push(market.askHeap, price)
+	}
+	level.Orders = append(level.Orders, order)
+}
+
+// bestBidLevel returns the highest-priced non-empty bid level, lazily
+// popping stale (already-emptied) price entries off the heap.
+// Callers must hold market.mu.
+func (m *Market) bestBidLevel() *PriceLevel {
+	for m.bidHeap.Len() > 0 {
+		price := (*m.bidHeap)[0]
+		level, ok := m.BidLevels[price]
+		if !ok || len(level.Orders) == 0 {
+			heap.Pop(m.bidHeap)
+			delete(m.BidLevels, price)
+			continue
+		}
+		return level
+	}
+	return nil
+}
+
+// bestAskLevel is the ask-side mirror of bestBidLevel.
+func (m *Market) bestAskLevel() *PriceLevel {
+	for m.askHeap.Len() > 0 {
+		price := (*m.askHeap)[0]
+		level, ok := m.AskLevels[price]
+		if !ok || len(level.Orders) == 0 {
+			heap.Pop(m.askHeap)
+			delete(m.AskLevels, price)
+			continue
+		}
+		return level
+	}
+	return nil
+}
+
+// cancelTaggedOrders marks every resting order on the given side carrying
+// makerSource as canceled and removes it from its price level. Emptied
+// levels are dropped from their map; the heap cleans up the stale price
+// lazily the next time bestBidLevel/bestAskLevel is called.
+// Callers must hold market.mu.
+func cancelTaggedOrders(market *Market, side string, makerSource string) {
+	levels := market.BidLevels
+	if side == "ASK" {
+		levels = market.AskLevels
+	}
+
+	for price, level := range levels {
+		kept := level.Orders[:0]
+		for _, o := range level.Orders {
+			if o.MakerSource == makerSource && o.LeavesQty > 0 {
+				o.Status = "CANCELED"
+				o.LeavesQty = 0
+				o.LastModified = time.Now().Unix()
+				continue
+			}
+			kept = append(kept, o)
+		}
+		level.Orders = kept
+		if len(level.Orders) == 0 {
+			delete(levels, price)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// BracketTarget is one take-profit scale-out leg of a bracket order: the
+// favorable ProfitPercentage move off the parent's average fill price at
+// which to exit, and the QuantityPercentage of the filled size it covers.
+type BracketTarget struct {
+	ProfitPercentage   float64 `json:"profit_percentage"`
+	QuantityPercentage float64 `json:"quantity_percentage"`
+}
+
+// defaultMinNotional is used when a bracket order doesn't set MinNotional.
+const defaultMinNotional = 1.0
+
+var (
+	ocoMu     sync.Mutex
+	ocoGroups = make(map[string]map[string]*Order) // group ID -> leg order ID -> leg
+)
+
+// validateBracketOrder checks a BRACKET submission's Targets and protective
+// stop before the parent is allowed to enter the book.
+func validateBracketOrder(order *Order) error {
+	if len(order.Targets) == 0 {
+		return fmt.Errorf("targets must include at least one profit/quantity pair")
+	}
+	qtySum := 0.0
+	for _, t := range order.Targets {
+		if t.ProfitPercentage <= 0 || t.QuantityPercentage <= 0 {
+			return fmt.Errorf("target profit_percentage and quantity_percentage must be > 0")
+		}
+		qtySum += t.QuantityPercentage
+	}
+	if qtySum > 1.0001 {
+		return fmt.Errorf("target quantity_percentage values must not sum to more than 1.0")
+	}
+	if order.StopPrice == nil && order.TrailingPercent == nil && order.TrailingAmount == nil {
+		return fmt.Errorf("bracket orders require a stop_price or a trailing stop for the protective leg")
+	}
+	return nil
+}
+
+// processBracketOrder enters the parent like a market order, then — for
+// whatever quantity actually filled — spawns an OCO group of take-profit
+// legs (one per Target) plus one protective stop-loss leg.
+func processBracketOrder(market *Market, order *Order) error {
+	if err := validateBracketOrder(order); err != nil {
+		return err
+	}
+
+	market.mu.Lock()
+	if order.Side == "BUY" {
+		executeAgainstAsks(market, order)
+	} else {
+		executeAgainstBids(market, order)
+	}
+	switch {
+	case order.LeavesQty <= 0:
+		order.Status = "FILLED"
+	case order.FilledQty > 0:
+		order.Status = "PARTIAL"
+	default:
+		order.Status = "NEW"
+	}
+	order.LastModified = time.Now().Unix()
+	market.Orders[order.ID] = order
+	filledQty := order.FilledQty
+	avgPrice := order.AvgPrice
+	market.mu.Unlock()
+
+	if filledQty > 0 && avgPrice != nil {
+		spawnBracketLegs(market, order, filledQty, *avgPrice)
+	}
+	return nil
+}
+
+// spawnBracketLegs builds the OCO group for a filled bracket parent: one
+// resting take-profit limit per Target, sized off filledQty and priced off
+// avgFillPrice, plus one protective stop-loss covering the full position.
+func spawnBracketLegs(market *Market, parent *Order, filledQty int, avgPrice float64) {
+	exitSide := "SELL"
+	if parent.Side == "SELL" {
+		exitSide = "BUY"
+	}
+	groupID := fmt.Sprintf("oco_%s", parent.ID)
+	minNotional := parent.MinNotional
+	if minNotional <= 0 {
+		minNotional = defaultMinNotional
+	}
+
+	market.mu.Lock()
+	defer market.mu.Unlock()
+
+	var legs []*Order
+	for i, target := range parent.Targets {
+		qty := int(math.Round(float64(filledQty) * target.QuantityPercentage))
+		if qty <= 0 {
+			continue
+		}
+		price := avgPrice * (1 + target.ProfitPercentage)
+		if parent.Side == "SELL" {
+			price = avgPrice * (1 - target.ProfitPercentage)
+		}
+		if float64(qty)*price < minNotional {
+			log.Printf("⚠️ bracket %s: target %d notional %.2f below min_notional %.2f, dropping leg", parent.ID, i, float64(qty)*price, minNotional)
+			continue
+		}
+
+		leg := &Order{
+			ID:           fmt.Sprintf("%s_tp_%d", parent.ID, i),
+			Symbol:       parent.Symbol,
+			Side:         exitSide,
+			OrderType:    "LIMIT",
+			Qty:          qty,
+			LimitPrice:   &price,
+			TIF:          "DAY",
+			Status:       "NEW",
+			LeavesQty:    qty,
+			CreatedAt:    time.Now().Unix(),
+			LastModified: time.Now().Unix(),
+			MakerSource:  "bracket:" + groupID,
+			GroupID:      groupID,
+		}
+		if exitSide == "SELL" {
+			executeAgainstBids(market, leg)
+			if leg.LeavesQty > 0 {
+				insertAsk(market, leg)
+			}
+		} else {
+			executeAgainstAsks(market, leg)
+			if leg.LeavesQty > 0 {
+				insertBid(market, leg)
+			}
+		}
+		if leg.LeavesQty <= 0 {
+			leg.Status = "FILLED"
+		} else if leg.FilledQty > 0 {
+			leg.Status = "PARTIAL"
+		}
+		market.Orders[leg.ID] = leg
+		legs = append(legs, leg)
+	}
+
+	stopLeg := &Order{
+		ID:              fmt.Sprintf("%s_sl", parent.ID),
+		Symbol:          parent.Symbol,
+		Side:            exitSide,
+		Qty:             filledQty,
+		TIF:             "DAY",
+		Status:          "PENDING",
+		LeavesQty:       filledQty,
+		CreatedAt:       time.Now().Unix(),
+		LastModified:    time.Now().Unix(),
+		MakerSource:     "bracket:" + groupID,
+		GroupID:         groupID,
+		StopPrice:       parent.StopPrice,
+		TrailingPercent: parent.TrailingPercent,
+		TrailingAmount:  parent.TrailingAmount,
+		ActivationPrice: parent.ActivationPrice,
+		Virtual:         parent.Virtual,
+	}
+	if stopLeg.TrailingPercent != nil || stopLeg.TrailingAmount != nil {
+		stopLeg.OrderType = "TRAILING_STOP"
+	} else {
+		stopLeg.OrderType = "STOP"
+	}
+	market.Orders[stopLeg.ID] = stopLeg
+	registerConditionalOrder(market, stopLeg)
+	legs = append(legs, stopLeg)
+
+	ocoMu.Lock()
+	group := make(map[string]*Order, len(legs))
+	for _, leg := range legs {
+		group[leg.ID] = leg
+	}
+	ocoGroups[groupID] = group
+	ocoMu.Unlock()
+
+	for _, leg := range legs {
+		go sendExecutionCallback(*leg)
+		// A leg can fill instantly as a taker against resting liquidity the
+		// moment it's created - fully (LeavesQty == 0) or partially (some
+		// FilledQty but still resting). Either way the siblings must be
+		// resized/canceled now, not just on a later fill.
+		if leg.FilledQty > 0 {
+			settleOCOFill(market, leg, leg.FilledQty)
+		}
+	}
+}
+
+// settleOCOFill applies one OCO leg's fill to its siblings. The group's
+// stop-loss leg and its take-profit legs aren't interchangeable: the stop
+// firing means the whole remaining position just closed, so every
+// take-profit leg is canceled; a take-profit leg firing (fully or
+// partially) only shrinks the stop's remaining coverage by the same
+// quantity - sibling take-profit legs keep resting toward their own targets
+// and are only exhausted (and the stop canceled alongside them) once every
+// take-profit leg has itself been filled or canceled. Callers must hold
+// market.mu.
+func settleOCOFill(market *Market, leg *Order, filledThisEvent int) {
+	ocoMu.Lock()
+	defer ocoMu.Unlock()
+
+	group, ok := ocoGroups[leg.GroupID]
+	if !ok {
+		return
+	}
+
+	if isConditionalOrderType(leg.OrderType) {
+		for id, sibling := range group {
+			if id != leg.ID {
+				cancelOCOLegLocked(market, sibling)
+			}
+		}
+		delete(ocoGroups, leg.GroupID)
+		return
+	}
+
+	allTargetsDone := leg.LeavesQty <= 0
+	if allTargetsDone {
+		for id, sibling := range group {
+			if id == leg.ID || isConditionalOrderType(sibling.OrderType) {
+				continue
+			}
+			if sibling.LeavesQty > 0 {
+				allTargetsDone = false
+				break
+			}
+		}
+	}
+
+	for id, sibling := range group {
+		if id == leg.ID || !isConditionalOrderType(sibling.OrderType) {
+			continue
+		}
+		if allTargetsDone {
+			cancelOCOLegLocked(market, sibling)
+		} else {
+			reduceOCOLegLocked(market, sibling, filledThisEvent)
+		}
+	}
+
+	if allTargetsDone {
+		delete(ocoGroups, leg.GroupID)
+	}
+}
+
+// cancelOCOGroup cancels every leg in groupID other than exceptID, e.g. when
+// the user explicitly cancels one leg via DELETE /orders/{id}. Callers must
+// hold market.mu.
+func cancelOCOGroup(market *Market, groupID, exceptID string) {
+	ocoMu.Lock()
+	defer ocoMu.Unlock()
+
+	group, ok := ocoGroups[groupID]
+	if !ok {
+		return
+	}
+	for id, sibling := range group {
+		if id == exceptID {
+			continue
+		}
+		cancelOCOLegLocked(market, sibling)
+	}
+	delete(ocoGroups, groupID)
+}
+
+// cancelOCOLegLocked cancels one sibling leg, pulling it from the book if it
+// was resting. Callers must hold market.mu and ocoMu.
+func cancelOCOLegLocked(market *Market, leg *Order) {
+	if leg.LeavesQty <= 0 || leg.Status == "FILLED" || leg.Status == "CANCELED" {
+		return
+	}
+	removeOrderFromBook(market, sideOf(leg.Side), leg)
+	leg.Status = "CANCELED"
+	leg.LeavesQty = 0
+	leg.LastModified = time.Now().Unix()
+	if isConditionalOrderType(leg.OrderType) {
+		releaseConditionalOrder(leg)
+	}
+	go sendExecutionCallback(*leg)
+}
+
+// reduceOCOLegLocked shrinks a resting sibling by amount, canceling it
+// outright if that exhausts its remaining quantity. Callers must hold
+// market.mu and ocoMu.
+func reduceOCOLegLocked(market *Market, leg *Order, amount int) {
+	reduction := amount
+	if reduction > leg.LeavesQty {
+		reduction = leg.LeavesQty
+	}
+	if leg.LimitPrice != nil {
+		leg.Qty -= reduction
+	}
+	leg.LeavesQty -= reduction
+	if leg.LeavesQty <= 0 {
+		cancelOCOLegLocked(market, leg)
+		return
+	}
+	leg.LastModified = time.Now().Unix()
+}
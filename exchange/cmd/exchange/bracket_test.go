@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+// newOCOGroup registers a 2-target take-profit group plus a protective stop
+// directly in ocoGroups, bypassing spawnBracketLegs's order-book plumbing so
+// settleOCOFill can be exercised in isolation.
+func newOCOGroup(t *testing.T, groupID string, qty int) (tp1, tp2, stop *Order) {
+	t.Helper()
+
+	tp1 = &Order{ID: groupID + "_tp_0", GroupID: groupID, OrderType: "LIMIT", Qty: qty / 2, LeavesQty: qty / 2}
+	tp2 = &Order{ID: groupID + "_tp_1", GroupID: groupID, OrderType: "LIMIT", Qty: qty / 2, LeavesQty: qty / 2}
+	stop = &Order{ID: groupID + "_sl", GroupID: groupID, OrderType: "STOP", Qty: qty, LeavesQty: qty, Status: "PENDING"}
+
+	ocoMu.Lock()
+	ocoGroups[groupID] = map[string]*Order{tp1.ID: tp1, tp2.ID: tp2, stop.ID: stop}
+	ocoMu.Unlock()
+
+	return tp1, tp2, stop
+}
+
+// TestSettleOCOFill_TakeProfitLegDoesNotCancelSiblingTargets verifies that
+// one take-profit leg filling completely only shrinks the protective stop's
+// remaining coverage - it must not cancel a sibling take-profit leg that
+// hasn't reached its own target yet.
+func TestSettleOCOFill_TakeProfitLegDoesNotCancelSiblingTargets(t *testing.T) {
+	market := &Market{Symbol: "XXXUSD"}
+	tp1, tp2, stop := newOCOGroup(t, "oco_1", 100)
+
+	tp1.FilledQty = 50
+	tp1.LeavesQty = 0
+	tp1.Status = "FILLED"
+
+	settleOCOFill(market, tp1, 50)
+
+	if tp2.Status == "CANCELED" {
+		t.Fatalf("tp2 must keep resting toward its own target, got CANCELED")
+	}
+	if tp2.LeavesQty != 50 {
+		t.Fatalf("tp2.LeavesQty = %d, want unchanged 50", tp2.LeavesQty)
+	}
+	if stop.Status == "CANCELED" {
+		t.Fatalf("stop must still cover the remaining position, got CANCELED")
+	}
+	if stop.LeavesQty != 50 {
+		t.Fatalf("stop.LeavesQty = %d, want reduced to 50", stop.LeavesQty)
+	}
+}
+
+// TestSettleOCOFill_StopCanceledOnceAllTargetsFilled verifies the stop is
+// only canceled once every take-profit leg in the group is exhausted.
+func TestSettleOCOFill_StopCanceledOnceAllTargetsFilled(t *testing.T) {
+	market := &Market{Symbol: "XXXUSD"}
+	tp1, tp2, stop := newOCOGroup(t, "oco_2", 100)
+
+	tp1.FilledQty = 50
+	tp1.LeavesQty = 0
+	tp1.Status = "FILLED"
+	settleOCOFill(market, tp1, 50)
+
+	if stop.Status == "CANCELED" {
+		t.Fatalf("stop should still be resting after only tp1 filled")
+	}
+
+	tp2.FilledQty = 50
+	tp2.LeavesQty = 0
+	tp2.Status = "FILLED"
+	settleOCOFill(market, tp2, 50)
+
+	if stop.Status != "CANCELED" {
+		t.Fatalf("stop.Status = %s, want CANCELED once both take-profit legs are exhausted", stop.Status)
+	}
+}
+
+// TestSettleOCOFill_StopFillingCancelsTakeProfitLegs verifies the classic
+// OCO direction still holds: the protective stop firing closes the position,
+// so every take-profit leg still resting must be canceled.
+func TestSettleOCOFill_StopFillingCancelsTakeProfitLegs(t *testing.T) {
+	market := &Market{Symbol: "XXXUSD"}
+	tp1, tp2, stop := newOCOGroup(t, "oco_3", 100)
+
+	stop.LeavesQty = 0
+	stop.Status = "FILLED"
+	settleOCOFill(market, stop, 100)
+
+	if tp1.Status != "CANCELED" || tp2.Status != "CANCELED" {
+		t.Fatalf("both take-profit legs should be canceled once the stop fills, got tp1=%s tp2=%s", tp1.Status, tp2.Status)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// isConditionalOrderType reports whether orderType is one of the
+// stop/trailing-stop variants that rest as PENDING rather than booking
+// directly, and so is eligible for virtual/non-virtual handling.
+func isConditionalOrderType(orderType string) bool {
+	switch orderType {
+	case "STOP", "STOP_LIMIT", "TRAILING_STOP", "TRAILING_STOP_LIMIT":
+		return true
+	default:
+		return false
+	}
+}
+
+// conditionalOrderStore indexes non-virtual conditional orders by symbol so
+// they can be listed via the API. Virtual orders never enter this store -
+// they live only in market.Orders, per Virtual's doc comment on Order.
+type conditionalOrderStore struct {
+	mu       sync.RWMutex
+	bySymbol map[string]map[string]*Order // symbol -> order ID -> order
+}
+
+var conditionalOrders = &conditionalOrderStore{
+	bySymbol: make(map[string]map[string]*Order),
+}
+
+func (s *conditionalOrderStore) add(order *Order) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bySymbol[order.Symbol] == nil {
+		s.bySymbol[order.Symbol] = make(map[string]*Order)
+	}
+	s.bySymbol[order.Symbol][order.ID] = order
+}
+
+func (s *conditionalOrderStore) remove(symbol, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.bySymbol[symbol], id)
+}
+
+func (s *conditionalOrderStore) list(symbol string) []*Order {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orders := make([]*Order, 0, len(s.bySymbol[symbol]))
+	for _, o := range s.bySymbol[symbol] {
+		orders = append(orders, o)
+	}
+	return orders
+}
+
+// reservedBalance tracks notional reserved by resting non-virtual
+// conditional orders, per symbol and order ID. A real exchange would reserve
+// against an asset wallet; this simulated one only ever trades one symbol's
+// notional at a time, so a per-symbol ledger is the natural analogue. Each
+// order's reservation is recorded under its own ID (not re-derived from the
+// order at release time) since a trailing stop's StopPrice - and so
+// conditionalNotional(order) - moves between registration and release;
+// releasing a re-derived amount could over- or under-release relative to
+// what was actually reserved, and would bleed into other orders' entries in
+// the same symbol bucket.
+var reservedBalance = struct {
+	mu       sync.Mutex
+	bySymbol map[string]map[string]float64 // symbol -> order ID -> amount reserved at registration
+}{bySymbol: make(map[string]map[string]float64)}
+
+func reserveBalance(symbol, orderID string, notional float64) {
+	reservedBalance.mu.Lock()
+	defer reservedBalance.mu.Unlock()
+	if reservedBalance.bySymbol[symbol] == nil {
+		reservedBalance.bySymbol[symbol] = make(map[string]float64)
+	}
+	reservedBalance.bySymbol[symbol][orderID] = notional
+}
+
+func releaseBalance(symbol, orderID string) {
+	reservedBalance.mu.Lock()
+	defer reservedBalance.mu.Unlock()
+	delete(reservedBalance.bySymbol[symbol], orderID)
+}
+
+// totalReservedBalance sums every order's reservation currently held for
+// symbol.
+func totalReservedBalance(symbol string) float64 {
+	reservedBalance.mu.Lock()
+	defer reservedBalance.mu.Unlock()
+	total := 0.0
+	for _, amount := range reservedBalance.bySymbol[symbol] {
+		total += amount
+	}
+	return total
+}
+
+// conditionalNotional estimates the notional a conditional order will need
+// to execute at, for balance-reservation purposes: the most specific price
+// it carries, in order of preference. A trailing stop gated purely by
+// MinProfitPercent (no LimitPrice/StopPrice yet, possibly no ActivationPrice
+// either) falls through to activationThreshold, the same price its trail
+// arms at, rather than reserving $0 while dormant.
+func conditionalNotional(order *Order) float64 {
+	switch {
+	case order.LimitPrice != nil:
+		return *order.LimitPrice * float64(order.Qty)
+	case order.StopPrice != nil:
+		return *order.StopPrice * float64(order.Qty)
+	default:
+		if threshold, ok := activationThreshold(order); ok {
+			return threshold * float64(order.Qty)
+		}
+		return 0
+	}
+}
+
+// registerConditionalOrder files a newly-PENDING stop/trailing-stop order
+// into the queryable conditional-order view and, for non-virtual orders,
+// reserves the balance it will need at trigger time. Virtual orders are
+// left purely in market.Orders and never lock balance or appear here.
+func registerConditionalOrder(market *Market, order *Order) {
+	if order.Virtual {
+		return
+	}
+	conditionalOrders.add(order)
+	reserveBalance(order.Symbol, order.ID, conditionalNotional(order))
+}
+
+// releaseConditionalOrder is the inverse of registerConditionalOrder, called
+// once a conditional order leaves the PENDING state (triggered or canceled).
+// It releases exactly what was reserved at registration time, not whatever
+// conditionalNotional(order) evaluates to now - see reservedBalance's doc.
+func releaseConditionalOrder(order *Order) {
+	if order.Virtual {
+		return
+	}
+	conditionalOrders.remove(order.Symbol, order.ID)
+	releaseBalance(order.Symbol, order.ID)
+}
+
+// handleConditionalOrders lists the resting non-virtual conditional orders
+// for a symbol - the exchange-native stops that are holding a balance
+// reservation. Virtual orders are deliberately absent.
+func handleConditionalOrders(w http.ResponseWriter, r *http.Request) {
+	symbol := mux.Vars(r)["symbol"]
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conditionalOrders.list(symbol))
+}
+
+// handleReservedBalance reports the notional currently held by resting
+// non-virtual conditional orders for a symbol, per reserveBalance/
+// releaseBalance above.
+func handleReservedBalance(w http.ResponseWriter, r *http.Request) {
+	symbol := mux.Vars(r)["symbol"]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"symbol":   symbol,
+		"reserved": totalReservedBalance(symbol),
+	})
+}
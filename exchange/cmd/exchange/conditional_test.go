@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+// TestReleaseConditionalOrder_DriftingTrailingStopDoesNotOverrelease verifies
+// that releasing a trailing stop's reservation after its anchor has moved
+// doesn't touch another resting order's reservation on the same symbol -
+// each order's release must use the amount captured at registration, not
+// conditionalNotional(order) re-evaluated against the order's current price.
+func TestReleaseConditionalOrder_DriftingTrailingStopDoesNotOverrelease(t *testing.T) {
+	symbol := "XXXUSD"
+	market := &Market{Symbol: symbol}
+
+	stop := &Order{ID: "stop1", Symbol: symbol, Qty: 10, StopPrice: floatPtr(100)}
+	registerConditionalOrder(market, stop)
+
+	trail := &Order{ID: "trail1", Symbol: symbol, Qty: 10, StopPrice: floatPtr(95)}
+	registerConditionalOrder(market, trail)
+
+	if got, want := totalReservedBalance(symbol), 1000.0+950.0; got != want {
+		t.Fatalf("total reserved after registering both orders = %v, want %v", got, want)
+	}
+
+	// The trail's anchor runs up, dragging its StopPrice with it - but its
+	// reservation should still be released at the $950 it was registered
+	// for, not whatever its StopPrice has drifted to since.
+	*trail.StopPrice = 300
+	releaseConditionalOrder(trail)
+
+	if got, want := totalReservedBalance(symbol), 1000.0; got != want {
+		t.Fatalf("reserved balance after releasing the drifted trailing stop = %v, want %v (stop1's reservation should survive)", got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ohlc is one in-progress or closed bar for a (symbol, interval) pair.
+type ohlc struct {
+	Open, High, Low, Close float64
+	Start                  time.Time
+}
+
+// symbolKlines buffers OHLC bars for every interval currently being watched
+// by a pending order on this symbol. Buckets are created lazily the first
+// time an order references that interval.
+type symbolKlines struct {
+	mu      sync.Mutex
+	current map[string]*ohlc // interval -> in-progress bar
+	last    map[string]ohlc  // interval -> most recently closed bar
+}
+
+var (
+	klineMu      sync.Mutex
+	klineBuffers = make(map[string]*symbolKlines) // symbol -> buffer
+)
+
+func getSymbolKlines(symbol string) *symbolKlines {
+	klineMu.Lock()
+	defer klineMu.Unlock()
+	sk, ok := klineBuffers[symbol]
+	if !ok {
+		sk = &symbolKlines{current: make(map[string]*ohlc), last: make(map[string]ohlc)}
+		klineBuffers[symbol] = sk
+	}
+	return sk
+}
+
+// ensureKlineBucket starts a bar for interval if none is buffered yet,
+// seeded from the current market price. Called from checkStopOrders before
+// resolving a KLINE_* order's trigger price.
+func ensureKlineBucket(symbol, interval string, seedPrice float64) {
+	sk := getSymbolKlines(symbol)
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	if _, ok := sk.current[interval]; !ok {
+		sk.current[interval] = &ohlc{Open: seedPrice, High: seedPrice, Low: seedPrice, Close: seedPrice, Start: time.Now()}
+	}
+}
+
+// recordKlineTrade folds a trade print into every interval bucket currently
+// being watched on symbol, rolling a bucket into sk.last once its interval
+// has elapsed. Called from publishMarketData for every execution.
+func recordKlineTrade(symbol string, price float64, ts time.Time) {
+	sk := getSymbolKlines(symbol)
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+
+	for interval, bar := range sk.current {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			continue
+		}
+		if ts.Sub(bar.Start) >= d {
+			sk.last[interval] = *bar
+			sk.current[interval] = &ohlc{Open: price, High: price, Low: price, Close: price, Start: ts}
+			continue
+		}
+		if price > bar.High {
+			bar.High = price
+		}
+		if price < bar.Low {
+			bar.Low = price
+		}
+		bar.Close = price
+	}
+}
+
+// lastClosedBar returns the most recently closed bar for (symbol, interval),
+// or ok=false if the bucket hasn't completed a full bar yet.
+func lastClosedBar(symbol, interval string) (ohlc, bool) {
+	sk := getSymbolKlines(symbol)
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	bar, ok := sk.last[interval]
+	return bar, ok
+}
+
+// PriceSource resolves the reference price a triggered order is evaluated
+// against. Distinct sources let backtests replay recorded kline data
+// deterministically instead of reading the live simulated market.
+type PriceSource interface {
+	Resolve(market *Market, order *Order) (float64, bool)
+}
+
+type lastPriceSource struct{}
+
+func (lastPriceSource) Resolve(market *Market, order *Order) (float64, bool) {
+	return market.LastPrice, true
+}
+
+// markPriceSource and indexPriceSource have no dedicated feed in this
+// simulated exchange, so they fall back to the last trade price like LAST.
+type markPriceSource struct{}
+
+func (markPriceSource) Resolve(market *Market, order *Order) (float64, bool) {
+	return market.LastPrice, true
+}
+
+type indexPriceSource struct{}
+
+func (indexPriceSource) Resolve(market *Market, order *Order) (float64, bool) {
+	return market.LastPrice, true
+}
+
+// klineCloseSource only fires on bar close, preventing a noisy single-print
+// wick from tripping the stop.
+type klineCloseSource struct{}
+
+func (klineCloseSource) Resolve(market *Market, order *Order) (float64, bool) {
+	bar, ok := lastClosedBar(market.Symbol, order.Interval)
+	if !ok {
+		return 0, false
+	}
+	return bar.Close, true
+}
+
+// klineHighLowSource is wick-inclusive: it checks the bar's high for BUY
+// orders (covering a short) and its low for SELL orders (protecting a long),
+// so a stop still fires if price touched it intrabar even without closing past it.
+type klineHighLowSource struct{}
+
+func (klineHighLowSource) Resolve(market *Market, order *Order) (float64, bool) {
+	bar, ok := lastClosedBar(market.Symbol, order.Interval)
+	if !ok {
+		return 0, false
+	}
+	if order.Side == "BUY" {
+		return bar.High, true
+	}
+	return bar.Low, true
+}
+
+var priceSources = map[string]PriceSource{
+	"LAST":           lastPriceSource{},
+	"MARK":           markPriceSource{},
+	"INDEX":          indexPriceSource{},
+	"KLINE_CLOSE":    klineCloseSource{},
+	"KLINE_HIGH_LOW": klineHighLowSource{},
+}
+
+// resolveTriggerPrice looks up order's TriggerSource in the registry,
+// defaulting to LAST for orders that don't set one.
+func resolveTriggerPrice(market *Market, order *Order) (float64, bool) {
+	source, ok := priceSources[order.TriggerSource]
+	if !ok {
+		source = lastPriceSource{}
+	}
+	return source.Resolve(market, order)
+}
+
+func isKlineTriggerSource(source string) bool {
+	return source == "KLINE_CLOSE" || source == "KLINE_HIGH_LOW"
+}
+
+// validateTriggerSource rejects an unknown TriggerSource, or a KLINE_*
+// source missing a valid Interval.
+func validateTriggerSource(order *Order) error {
+	switch order.TriggerSource {
+	case "", "LAST", "MARK", "INDEX":
+		return nil
+	case "KLINE_CLOSE", "KLINE_HIGH_LOW":
+		if order.Interval == "" {
+			return fmt.Errorf("interval is required for trigger_source %s", order.TriggerSource)
+		}
+		if _, err := time.ParseDuration(order.Interval); err != nil {
+			return fmt.Errorf("invalid interval %q: %w", order.Interval, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported trigger_source %q", order.TriggerSource)
+	}
+}
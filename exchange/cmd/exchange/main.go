@@ -7,6 +7,7 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,33 +16,54 @@ import (
 
 // Order represents a trading order
 type Order struct {
-	ID             string  `json:"id"`
-	OrderID        string  `json:"order_id"` // For incoming orders from broker
-	Symbol         string  `json:"symbol"`
-	Side           string  `json:"side"`
-	OrderType      string  `json:"order_type"`
-	Qty            int     `json:"qty"`
-	LimitPrice     *float64 `json:"limit_price,omitempty"`
-	StopPrice      *float64 `json:"stop_price,omitempty"`
-	TrailingPercent *float64 `json:"trailing_percent,omitempty"`
-	TIF            string  `json:"tif"`
-	CallbackURL    string  `json:"callback_url"`
-	Status         string  `json:"status"`
-	FilledQty      int     `json:"filled_qty"`
-	LeavesQty      int     `json:"leaves_qty"`
-	AvgPrice       *float64 `json:"avg_price,omitempty"`
-	CreatedAt      int64   `json:"created_at"`
-	LastModified   int64   `json:"last_modified"`
-	ExecutionLog   []Execution `json:"execution_log,omitempty"`
+	ID               string      `json:"id"`
+	OrderID          string      `json:"order_id"` // For incoming orders from broker
+	Symbol           string      `json:"symbol"`
+	Side             string      `json:"side"`
+	OrderType        string      `json:"order_type"`
+	Qty              int         `json:"qty"`
+	LimitPrice       *float64    `json:"limit_price,omitempty"`
+	StopPrice        *float64    `json:"stop_price,omitempty"`
+	TrailingPercent  *float64    `json:"trailing_percent,omitempty"`
+	TIF              string      `json:"tif"`
+	CallbackURL      string      `json:"callback_url"`
+	Status           string      `json:"status"`
+	FilledQty        int         `json:"filled_qty"`
+	LeavesQty        int         `json:"leaves_qty"`
+	AvgPrice         *float64    `json:"avg_price,omitempty"`
+	CreatedAt        int64       `json:"created_at"`
+	LastModified     int64       `json:"last_modified"`
+	ExecutionLog     []Execution `json:"execution_log,omitempty"`
+	MakerSource      string      `json:"maker_source,omitempty"`       // e.g. "xmaker" - distinguishes internal mm quotes from external flow
+	NumOfTicks       int         `json:"num_of_ticks,omitempty"`       // TWAP: number of slices to schedule
+	SliceQty         int         `json:"slice_qty,omitempty"`          // TWAP: quantity per slice (default Qty/NumOfTicks)
+	DeadlineTime     int64       `json:"deadline_ts,omitempty"`        // TWAP: unix ts by which all slices must complete
+	HighestPrice     *float64    `json:"highest_price,omitempty"`      // trailing stop (SELL): high-water-mark since acceptance (since activation, if gated)
+	LowestPrice      *float64    `json:"lowest_price,omitempty"`       // trailing stop (BUY): low-water-mark since acceptance (since activation, if gated)
+	ActivationPrice  *float64    `json:"activation_price,omitempty"`   // trailing stop: absolute price that must be crossed before the trail arms
+	TrailingAmount   *float64    `json:"trailing_amount,omitempty"`    // trailing stop: absolute offset, alternative to TrailingPercent
+	MinProfitPercent *float64    `json:"min_profit_percent,omitempty"` // trailing stop: activation expressed relative to EntryPrice instead of an absolute price
+	EntryPrice       *float64    `json:"entry_price,omitempty"`        // trailing stop: reference entry price MinProfitPercent is measured from
+	Activated        bool        `json:"activated,omitempty"`          // trailing stop: true once price has crossed the activation threshold
+
+	Targets     []BracketTarget `json:"targets,omitempty"`      // bracket: take-profit scale-out legs, evaluated against the parent's avg fill price
+	GroupID     string          `json:"group_id,omitempty"`     // bracket/OCO: links sibling legs spawned from one parent fill
+	MinNotional float64         `json:"min_notional,omitempty"` // bracket: take-profit legs below this notional (qty*price) are dropped
+
+	TriggerSource string `json:"trigger_source,omitempty"` // stop variants: LAST (default), MARK, INDEX, KLINE_CLOSE, KLINE_HIGH_LOW
+	Interval      string `json:"interval,omitempty"`       // stop variants: bar size for KLINE_* trigger sources, e.g. "1m", "5m"
+
+	Virtual bool `json:"virtual,omitempty"` // stop variants: client-side simulated - no balance reservation, not listed in the conditional-orders view
 }
 
 // Execution represents a trade execution
 type Execution struct {
-	Timestamp     int64   `json:"timestamp"`
-	Price         float64 `json:"price"`
-	Qty           int     `json:"qty"`
-	Venue         string  `json:"venue"`
+	Timestamp         int64              `json:"timestamp"`
+	Price             float64            `json:"price"`
+	Qty               int                `json:"qty"`
+	Venue             string             `json:"venue"`
 	OrderBookSnapshot *OrderBookSnapshot `json:"order_book_snapshot,omitempty"`
+	MakerSource       string             `json:"maker_source,omitempty"`
 }
 
 // OrderBookEntry represents a price level in the order book
@@ -54,23 +76,27 @@ type OrderBookEntry struct {
 
 // OrderBookSnapshot represents the order book at a point in time
 type OrderBookSnapshot struct {
-	Symbol    string          `json:"symbol"`
-	Timestamp int64           `json:"timestamp"`
+	Symbol    string           `json:"symbol"`
+	Timestamp int64            `json:"timestamp"`
 	Bids      []OrderBookEntry `json:"bids"`
 	Asks      []OrderBookEntry `json:"asks"`
-	LastPrice *float64        `json:"last_price,omitempty"`
-	Volume    int             `json:"volume"`
+	LastPrice *float64         `json:"last_price,omitempty"`
+	Volume    int              `json:"volume"`
 }
 
 // Market represents the market for a symbol
 type Market struct {
 	Symbol      string
 	Orders      map[string]*Order
-	Bids        []*Order // Sorted by price (highest first)
-	Asks        []*Order // Sorted by price (lowest first)
+	BidLevels   map[float64]*PriceLevel // price -> FIFO queue of resting bids
+	AskLevels   map[float64]*PriceLevel // price -> FIFO queue of resting asks
+	bidHeap     *maxPriceHeap           // bid prices, highest first
+	askHeap     *minPriceHeap           // ask prices, lowest first
 	LastPrice   float64
 	LastTrade   time.Time
 	Volume      int
+	MakerSource string // set once an xmaker subsystem is attached to this market
+	XMaker      *XMakerState
 	mu          sync.RWMutex
 }
 
@@ -89,12 +115,26 @@ func main() {
 
 	// API endpoints
 	r.HandleFunc("/orders", handlePlaceOrder).Methods("POST")
+	r.HandleFunc("/orders/{id}", handleCancelOrder).Methods("DELETE")
+	r.HandleFunc("/orders/{id}", handleAmendOrder).Methods("PUT")
 	r.HandleFunc("/order-book/{symbol}", handleGetOrderBook).Methods("GET")
+	r.HandleFunc("/markets/{symbol}/xmaker", handleSetXMakerConfig).Methods("POST")
+	r.HandleFunc("/arb/paths", handleArbPaths).Methods("GET", "POST")
+	r.HandleFunc("/arb/opportunities", handleArbOpportunities).Methods("GET")
+	r.HandleFunc("/ws/marketdata/{symbol}", handleMarketDataWS).Methods("GET")
+	r.HandleFunc("/conditional-orders/{symbol}", handleConditionalOrders).Methods("GET")
+	r.HandleFunc("/reserved-balance/{symbol}", handleReservedBalance).Methods("GET")
 	r.HandleFunc("/health", handleHealth).Methods("GET")
 
+	// Recover virtual conditional orders persisted by a prior process before
+	// serving traffic.
+	restoreVirtualOrders()
+
 	// Start background processes
 	go startMarketMaking()
 	go startStopOrderMonitoring()
+	go startArbitrageScanner()
+	go startVirtualOrderRecovery()
 
 	log.Println("Exchange starting on :8081")
 	log.Fatal(http.ListenAndServe(":8081", r))
@@ -115,7 +155,7 @@ func handlePlaceOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("📥 Received order: %s %s %d %s (OrderID: %s, ID: %s)", 
+	log.Printf("📥 Received order: %s %s %d %s (OrderID: %s, ID: %s)",
 		order.Symbol, order.Side, order.Qty, order.OrderType, order.OrderID, order.ID)
 
 	// Validate order
@@ -144,6 +184,19 @@ func handlePlaceOrder(w http.ResponseWriter, r *http.Request) {
 	// Get or create market
 	market := exchange.getOrCreateMarket(order.Symbol)
 
+	// Idempotency: a resubmission of an order ID we've already booked
+	// returns the existing order's current status rather than double-booking.
+	market.mu.RLock()
+	if existing, ok := market.Orders[order.ID]; ok {
+		market.mu.RUnlock()
+		log.Printf("🔁 Duplicate submission for order %s, returning existing status", order.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(existing)
+		return
+	}
+	market.mu.RUnlock()
+
 	// Process order based on type
 	switch order.OrderType {
 	case "MARKET":
@@ -155,21 +208,58 @@ func handlePlaceOrder(w http.ResponseWriter, r *http.Request) {
 		// Send callback after limit order processing
 		go sendExecutionCallback(order)
 	case "STOP":
+		if err := validateTriggerSource(&order); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		processStopOrder(market, &order)
 		// Send callback after stop order processing
 		go sendExecutionCallback(order)
 	case "STOP_LIMIT":
+		if err := validateTriggerSource(&order); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		processStopLimitOrder(market, &order)
 		// Send callback after stop limit order processing
 		go sendExecutionCallback(order)
 	case "TRAILING_STOP":
+		if err := validateActivationGate(&order); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateTriggerSource(&order); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		processTrailingStopOrder(market, &order)
 		// Send callback after trailing stop order processing
 		go sendExecutionCallback(order)
 	case "TRAILING_STOP_LIMIT":
+		if err := validateActivationGate(&order); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateTriggerSource(&order); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		processTrailingStopLimitOrder(market, &order)
 		// Send callback after trailing stop limit order processing
 		go sendExecutionCallback(order)
+	case "TWAP":
+		if err := processTwapOrder(market, &order); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Slices are scheduled asynchronously; callbacks fire per-slice and on completion.
+	case "BRACKET":
+		if err := processBracketOrder(market, &order); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// OCO legs are spawned off the fill and callback independently.
+		go sendExecutionCallback(order)
 	default:
 		http.Error(w, "Unsupported order type", http.StatusBadRequest)
 		return
@@ -201,21 +291,25 @@ func (e *Exchange) getOrCreateMarket(symbol string) *Market {
 		return market
 	}
 
+	bidHeap := make(maxPriceHeap, 0)
+	askHeap := make(minPriceHeap, 0)
 	market := &Market{
 		Symbol:    symbol,
 		Orders:    make(map[string]*Order),
-		Bids:      make([]*Order, 0),
-		Asks:      make([]*Order, 0),
+		BidLevels: make(map[float64]*PriceLevel),
+		AskLevels: make(map[float64]*PriceLevel),
+		bidHeap:   &bidHeap,
+		askHeap:   &askHeap,
 		LastPrice: 100.0 + float64(rand.Intn(200)), // Random starting price
 		LastTrade: time.Now(),
 		Volume:    0,
 	}
 
 	e.Markets[symbol] = market
-	
+
 	// Add initial market making orders
 	addInitialMarketMaking(market)
-	
+
 	return market
 }
 
@@ -228,54 +322,74 @@ func (e *Exchange) getMarket(symbol string) *Market {
 func (m *Market) getOrderBookSnapshot() OrderBookSnapshot {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	return m.snapshotLocked()
+}
 
-	bids := make([]OrderBookEntry, 0, len(m.Bids))
-	asks := make([]OrderBookEntry, 0, len(m.Asks))
+// snapshotLocked builds an OrderBookSnapshot assuming the caller already
+// holds m.mu (read or write). Used by the match loop to capture a snapshot
+// for market-data publishing without re-entering the mutex.
+//
+// Bids/Asks are built from a sorted copy of the price heaps rather than a
+// map range, so the result is both in strict price order and O(n log n) on
+// the number of distinct price levels instead of requiring a second,
+// unordered full-map pass.
+func (m *Market) snapshotLocked() OrderBookSnapshot {
+	bids := sortedLevelEntries(m.BidLevels, *m.bidHeap, true)
+	asks := sortedLevelEntries(m.AskLevels, *m.askHeap, false)
 
-	// Aggregate bids by price
-	bidLevels := make(map[float64]int)
-	for _, order := range m.Bids {
-		if order.LeavesQty > 0 {
-			bidLevels[*order.LimitPrice] += order.LeavesQty
-		}
+	return OrderBookSnapshot{
+		Symbol:    m.Symbol,
+		Timestamp: time.Now().Unix(),
+		Bids:      bids,
+		Asks:      asks,
+		LastPrice: &m.LastPrice,
+		Volume:    m.Volume,
 	}
+}
 
-	// Convert to entries
-	for price, size := range bidLevels {
-		bids = append(bids, OrderBookEntry{
-			Price:     price,
-			Size:      size,
-			Venue:     "SIMX",
-			Timestamp: time.Now().Unix(),
-		})
+// sortedLevelEntries renders levels in price order (descending for bids,
+// ascending for asks) from a snapshot of the corresponding heap's prices.
+// The heap can carry stale or duplicate entries (a level deleted from the
+// map by cancelTaggedOrders, removeOrderFromBook or the match loop keeps its
+// price in the heap, and the next insert at that price pushes it again), so
+// entries are deduped and any price no
+// longer present in levels - or emptied down to zero leaves qty - is skipped.
+func sortedLevelEntries(levels map[float64]*PriceLevel, heapPrices []float64, descending bool) []OrderBookEntry {
+	prices := append([]float64(nil), heapPrices...)
+	if descending {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	} else {
+		sort.Float64s(prices)
 	}
 
-	// Aggregate asks by price
-	askLevels := make(map[float64]int)
-	for _, order := range m.Asks {
-		if order.LeavesQty > 0 {
-			askLevels[*order.LimitPrice] += order.LeavesQty
+	now := time.Now().Unix()
+	seen := make(map[float64]bool, len(prices))
+	entries := make([]OrderBookEntry, 0, len(prices))
+	for _, price := range prices {
+		if seen[price] {
+			continue
 		}
-	}
+		seen[price] = true
 
-	// Convert to entries
-	for price, size := range askLevels {
-		asks = append(asks, OrderBookEntry{
+		level, ok := levels[price]
+		if !ok {
+			continue
+		}
+		size := 0
+		for _, order := range level.Orders {
+			size += order.LeavesQty
+		}
+		if size == 0 {
+			continue
+		}
+		entries = append(entries, OrderBookEntry{
 			Price:     price,
 			Size:      size,
 			Venue:     "SIMX",
-			Timestamp: time.Now().Unix(),
+			Timestamp: now,
 		})
 	}
-
-	return OrderBookSnapshot{
-		Symbol:    m.Symbol,
-		Timestamp: time.Now().Unix(),
-		Bids:      bids,
-		Asks:      asks,
-		LastPrice: &m.LastPrice,
-		Volume:    m.Volume,
-	}
+	return entries
 }
 
 func processMarketOrder(market *Market, order *Order) {
@@ -283,7 +397,7 @@ func processMarketOrder(market *Market, order *Order) {
 	defer market.mu.Unlock()
 
 	log.Printf("📈 Processing market order: %s %s %d shares", order.Side, order.Symbol, order.Qty)
-	log.Printf("📊 Market has %d bids and %d asks", len(market.Bids), len(market.Asks))
+	log.Printf("📊 Market has %d bid levels and %d ask levels", len(market.BidLevels), len(market.AskLevels))
 
 	// Market orders execute immediately against the opposite side
 	if order.Side == "BUY" {
@@ -295,7 +409,7 @@ func processMarketOrder(market *Market, order *Order) {
 	// If no executions happened, create a synthetic execution at market price
 	if len(order.ExecutionLog) == 0 && order.Status == "FILLED" {
 		log.Printf("⚠️ Creating synthetic execution for market order: %s", order.ID)
-		
+
 		// Use market price for synthetic execution
 		execution := Execution{
 			Timestamp: time.Now().Unix(),
@@ -367,6 +481,7 @@ func processStopOrder(market *Market, order *Order) {
 	} else {
 		// Stop not triggered - store for later
 		order.Status = "PENDING"
+		registerConditionalOrder(market, order)
 	}
 
 	order.LastModified = time.Now().Unix()
@@ -407,6 +522,7 @@ func processStopLimitOrder(market *Market, order *Order) {
 	} else {
 		// Stop not triggered - store for later
 		order.Status = "PENDING"
+		registerConditionalOrder(market, order)
 	}
 
 	order.LastModified = time.Now().Unix()
@@ -417,26 +533,31 @@ func processTrailingStopOrder(market *Market, order *Order) {
 	market.mu.Lock()
 	defer market.mu.Unlock()
 
-	// Calculate trailing stop price
-	trailingAmount := market.LastPrice * (*order.TrailingPercent / 100)
-	var stopPrice float64
-	if order.Side == "BUY" {
-		stopPrice = market.LastPrice - trailingAmount
-	} else {
-		stopPrice = market.LastPrice + trailingAmount
+	checkActivation(order, market.LastPrice)
+	if isDormant(order) {
+		order.Status = "PENDING"
+		order.LastModified = time.Now().Unix()
+		market.Orders[order.ID] = order
+		registerConditionalOrder(market, order)
+		return
 	}
 
+	initTrailingAnchor(order, market.LastPrice)
+	updateTrailingAnchor(order, market.LastPrice)
+	stopPrice := trailingStopPrice(order)
+
 	// Check if trailing stop is triggered
-	if order.Side == "BUY" && market.LastPrice <= stopPrice {
+	if order.Side == "BUY" && market.LastPrice >= stopPrice {
 		executeAgainstAsks(market, order)
 		order.Status = "FILLED"
-	} else if order.Side == "SELL" && market.LastPrice >= stopPrice {
+	} else if order.Side == "SELL" && market.LastPrice <= stopPrice {
 		executeAgainstBids(market, order)
 		order.Status = "FILLED"
 	} else {
-		// Update stop price and store for later
+		// Not triggered yet - store the current anchor-derived stop for later
 		order.StopPrice = &stopPrice
 		order.Status = "PENDING"
+		registerConditionalOrder(market, order)
 	}
 
 	order.LastModified = time.Now().Unix()
@@ -447,17 +568,21 @@ func processTrailingStopLimitOrder(market *Market, order *Order) {
 	market.mu.Lock()
 	defer market.mu.Unlock()
 
-	// Calculate trailing stop price
-	trailingAmount := market.LastPrice * (*order.TrailingPercent / 100)
-	var stopPrice float64
-	if order.Side == "BUY" {
-		stopPrice = market.LastPrice - trailingAmount
-	} else {
-		stopPrice = market.LastPrice + trailingAmount
+	checkActivation(order, market.LastPrice)
+	if isDormant(order) {
+		order.Status = "PENDING"
+		order.LastModified = time.Now().Unix()
+		market.Orders[order.ID] = order
+		registerConditionalOrder(market, order)
+		return
 	}
 
+	initTrailingAnchor(order, market.LastPrice)
+	updateTrailingAnchor(order, market.LastPrice)
+	stopPrice := trailingStopPrice(order)
+
 	// Check if trailing stop is triggered
-	if order.Side == "BUY" && market.LastPrice <= stopPrice {
+	if order.Side == "BUY" && market.LastPrice >= stopPrice {
 		executeAgainstAsks(market, order)
 		if order.LeavesQty > 0 {
 			insertBid(market, order)
@@ -469,7 +594,7 @@ func processTrailingStopLimitOrder(market *Market, order *Order) {
 		} else {
 			order.Status = "FILLED"
 		}
-	} else if order.Side == "SELL" && market.LastPrice >= stopPrice {
+	} else if order.Side == "SELL" && market.LastPrice <= stopPrice {
 		executeAgainstBids(market, order)
 		if order.LeavesQty > 0 {
 			insertAsk(market, order)
@@ -482,178 +607,161 @@ func processTrailingStopLimitOrder(market *Market, order *Order) {
 			order.Status = "FILLED"
 		}
 	} else {
-		// Update stop price and store for later
+		// Not triggered yet - store the current anchor-derived stop for later
 		order.StopPrice = &stopPrice
 		order.Status = "PENDING"
+		registerConditionalOrder(market, order)
 	}
 
 	order.LastModified = time.Now().Unix()
 	market.Orders[order.ID] = order
 }
 
+// executeAgainstAsks walks the ask side top-of-book level first, in strict
+// arrival order within each level (true price-time priority), deleting
+// exhausted levels from the map as they empty; their heap entries are popped
+// lazily by bestAskLevel.
 func executeAgainstAsks(market *Market, order *Order) {
-	log.Printf("🔍 Executing BUY order against %d asks", len(market.Asks))
-	
-	for i := 0; i < len(market.Asks) && order.LeavesQty > 0; i++ {
-		ask := market.Asks[i]
-		if ask.LeavesQty == 0 {
-			continue
+	for order.LeavesQty > 0 {
+		level := market.bestAskLevel()
+		if level == nil {
+			break
 		}
 
-		log.Printf("💰 Checking ask: price=%f, qty=%d", *ask.LimitPrice, ask.LeavesQty)
-
-		// Check if we can trade
-		if order.LimitPrice != nil && *order.LimitPrice < *ask.LimitPrice {
-			log.Printf("❌ Order limit price %f < ask price %f, stopping", *order.LimitPrice, *ask.LimitPrice)
+		if order.LimitPrice != nil && *order.LimitPrice < level.Price {
 			break
 		}
 
-		// Execute trade
-		tradeQty := min(order.LeavesQty, ask.LeavesQty)
-		tradePrice := *ask.LimitPrice
+		for len(level.Orders) > 0 && order.LeavesQty > 0 {
+			ask := level.Orders[0]
+			if ask.LeavesQty == 0 {
+				level.Orders = level.Orders[1:]
+				continue
+			}
 
-		log.Printf("✅ Executing trade: %d shares @ $%f", tradeQty, tradePrice)
+			tradeQty := min(order.LeavesQty, ask.LeavesQty)
+			tradePrice := level.Price
 
-		// Update orders
-		order.FilledQty += tradeQty
-		order.LeavesQty -= tradeQty
-		ask.FilledQty += tradeQty
-		ask.LeavesQty -= tradeQty
+			order.FilledQty += tradeQty
+			order.LeavesQty -= tradeQty
+			ask.FilledQty += tradeQty
+			ask.LeavesQty -= tradeQty
 
-		// Update average price
-		if order.AvgPrice == nil {
-			order.AvgPrice = &tradePrice
-		} else {
-			totalValue := float64(order.FilledQty-tradeQty)**order.AvgPrice + float64(tradeQty)*tradePrice
-			newAvg := totalValue / float64(order.FilledQty)
-			order.AvgPrice = &newAvg
-		}
+			if order.AvgPrice == nil {
+				order.AvgPrice = &tradePrice
+			} else {
+				totalValue := float64(order.FilledQty-tradeQty)**order.AvgPrice + float64(tradeQty)*tradePrice
+				newAvg := totalValue / float64(order.FilledQty)
+				order.AvgPrice = &newAvg
+			}
 
-		// Add execution log
-		execution := Execution{
-			Timestamp: time.Now().Unix(),
-			Price:     tradePrice,
-			Qty:       tradeQty,
-			Venue:     "SIMX",
-			OrderBookSnapshot: &OrderBookSnapshot{
-				Symbol:    market.Symbol,
+			execution := Execution{
 				Timestamp: time.Now().Unix(),
-				LastPrice: &tradePrice,
-			},
-		}
-		order.ExecutionLog = append(order.ExecutionLog, execution)
+				Price:     tradePrice,
+				Qty:       tradeQty,
+				Venue:     "SIMX",
+				OrderBookSnapshot: &OrderBookSnapshot{
+					Symbol:    market.Symbol,
+					Timestamp: time.Now().Unix(),
+					LastPrice: &tradePrice,
+				},
+				MakerSource: ask.MakerSource,
+			}
+			order.ExecutionLog = append(order.ExecutionLog, execution)
 
-		// Update market
-		market.LastPrice = tradePrice
-		market.LastTrade = time.Now()
-		market.Volume += tradeQty
+			market.LastPrice = tradePrice
+			market.LastTrade = time.Now()
+			market.Volume += tradeQty
+			publishMarketData(market, execution)
 
-		// Update ask order status
-		if ask.LeavesQty == 0 {
-			ask.Status = "FILLED"
-		} else {
-			ask.Status = "PARTIAL"
+			if ask.LeavesQty == 0 {
+				ask.Status = "FILLED"
+				level.Orders = level.Orders[1:]
+			} else {
+				ask.Status = "PARTIAL"
+			}
+			ask.LastModified = time.Now().Unix()
+			if ask.GroupID != "" {
+				settleOCOFill(market, ask, tradeQty)
+			}
 		}
-		ask.LastModified = time.Now().Unix()
-	}
 
-	// Remove filled asks from book
-	market.Asks = filterActiveOrders(market.Asks)
+		if len(level.Orders) == 0 {
+			delete(market.AskLevels, level.Price)
+		}
+	}
 }
 
+// executeAgainstBids is the bid-side mirror of executeAgainstAsks.
 func executeAgainstBids(market *Market, order *Order) {
-	for i := 0; i < len(market.Bids) && order.LeavesQty > 0; i++ {
-		bid := market.Bids[i]
-		if bid.LeavesQty == 0 {
-			continue
+	for order.LeavesQty > 0 {
+		level := market.bestBidLevel()
+		if level == nil {
+			break
 		}
 
-		// Check if we can trade
-		if order.LimitPrice != nil && *order.LimitPrice > *bid.LimitPrice {
+		if order.LimitPrice != nil && *order.LimitPrice > level.Price {
 			break
 		}
 
-		// Execute trade
-		tradeQty := min(order.LeavesQty, bid.LeavesQty)
-		tradePrice := *bid.LimitPrice
+		for len(level.Orders) > 0 && order.LeavesQty > 0 {
+			bid := level.Orders[0]
+			if bid.LeavesQty == 0 {
+				level.Orders = level.Orders[1:]
+				continue
+			}
 
-		// Update orders
-		order.FilledQty += tradeQty
-		order.LeavesQty -= tradeQty
-		bid.FilledQty += tradeQty
-		bid.LeavesQty -= tradeQty
+			tradeQty := min(order.LeavesQty, bid.LeavesQty)
+			tradePrice := level.Price
 
-		// Update average price
-		if order.AvgPrice == nil {
-			order.AvgPrice = &tradePrice
-		} else {
-			totalValue := float64(order.FilledQty-tradeQty)**order.AvgPrice + float64(tradeQty)*tradePrice
-			newAvg := totalValue / float64(order.FilledQty)
-			order.AvgPrice = &newAvg
-		}
-
-		// Add execution log
-		execution := Execution{
-			Timestamp: time.Now().Unix(),
-			Price:     tradePrice,
-			Qty:       tradeQty,
-			Venue:     "SIMX",
-			OrderBookSnapshot: &OrderBookSnapshot{
-				Symbol:    market.Symbol,
-				Timestamp: time.Now().Unix(),
-				LastPrice: &tradePrice,
-			},
-		}
-		order.ExecutionLog = append(order.ExecutionLog, execution)
+			order.FilledQty += tradeQty
+			order.LeavesQty -= tradeQty
+			bid.FilledQty += tradeQty
+			bid.LeavesQty -= tradeQty
 
-		// Update market
-		market.LastPrice = tradePrice
-		market.LastTrade = time.Now()
-		market.Volume += tradeQty
+			if order.AvgPrice == nil {
+				order.AvgPrice = &tradePrice
+			} else {
+				totalValue := float64(order.FilledQty-tradeQty)**order.AvgPrice + float64(tradeQty)*tradePrice
+				newAvg := totalValue / float64(order.FilledQty)
+				order.AvgPrice = &newAvg
+			}
 
-		// Update bid order status
-		if bid.LeavesQty == 0 {
-			bid.Status = "FILLED"
-		} else {
-			bid.Status = "PARTIAL"
-		}
-		bid.LastModified = time.Now().Unix()
-	}
+			execution := Execution{
+				Timestamp: time.Now().Unix(),
+				Price:     tradePrice,
+				Qty:       tradeQty,
+				Venue:     "SIMX",
+				OrderBookSnapshot: &OrderBookSnapshot{
+					Symbol:    market.Symbol,
+					Timestamp: time.Now().Unix(),
+					LastPrice: &tradePrice,
+				},
+				MakerSource: bid.MakerSource,
+			}
+			order.ExecutionLog = append(order.ExecutionLog, execution)
 
-	// Remove filled bids from book
-	market.Bids = filterActiveOrders(market.Bids)
-}
+			market.LastPrice = tradePrice
+			market.LastTrade = time.Now()
+			market.Volume += tradeQty
+			publishMarketData(market, execution)
 
-func insertBid(market *Market, order *Order) {
-	// Insert in price-time priority (highest price first, then earliest time)
-	for i, bid := range market.Bids {
-		if *order.LimitPrice > *bid.LimitPrice {
-			market.Bids = append(market.Bids[:i], append([]*Order{order}, market.Bids[i:]...)...)
-			return
+			if bid.LeavesQty == 0 {
+				bid.Status = "FILLED"
+				level.Orders = level.Orders[1:]
+			} else {
+				bid.Status = "PARTIAL"
+			}
+			bid.LastModified = time.Now().Unix()
+			if bid.GroupID != "" {
+				settleOCOFill(market, bid, tradeQty)
+			}
 		}
-	}
-	market.Bids = append(market.Bids, order)
-}
 
-func insertAsk(market *Market, order *Order) {
-	// Insert in price-time priority (lowest price first, then earliest time)
-	for i, ask := range market.Asks {
-		if *order.LimitPrice < *ask.LimitPrice {
-			market.Asks = append(market.Asks[:i], append([]*Order{order}, market.Asks[i:]...)...)
-			return
+		if len(level.Orders) == 0 {
+			delete(market.BidLevels, level.Price)
 		}
 	}
-	market.Asks = append(market.Asks, order)
-}
-
-func filterActiveOrders(orders []*Order) []*Order {
-	active := make([]*Order, 0)
-	for _, order := range orders {
-		if order.LeavesQty > 0 {
-			active = append(active, order)
-		}
-	}
-	return active
 }
 
 func min(a, b int) int {
@@ -667,50 +775,50 @@ func addInitialMarketMaking(market *Market) {
 	// Add 3-5 initial bids and asks
 	numBids := 3 + rand.Intn(3) // 3-5 bids
 	numAsks := 3 + rand.Intn(3) // 3-5 asks
-	
+
 	for i := 0; i < numBids; i++ {
 		bidPrice := market.LastPrice * (1 - float64(i+1)*0.005) // 0.5%, 1%, 1.5% below
-		bidQty := 100 + rand.Intn(900) // 100-1000 shares
-		
+		bidQty := 100 + rand.Intn(900)                          // 100-1000 shares
+
 		bidOrder := &Order{
-			ID:         fmt.Sprintf("init_bid_%d_%d", time.Now().UnixNano(), i),
-			Symbol:     market.Symbol,
-			Side:       "BUY",
-			OrderType:  "LIMIT",
-			Qty:        bidQty,
-			LimitPrice: &bidPrice,
-			TIF:        "DAY",
-			Status:     "NEW",
-			FilledQty:  0,
-			LeavesQty:  bidQty,
-			CreatedAt:  time.Now().Unix(),
+			ID:           fmt.Sprintf("init_bid_%d_%d", time.Now().UnixNano(), i),
+			Symbol:       market.Symbol,
+			Side:         "BUY",
+			OrderType:    "LIMIT",
+			Qty:          bidQty,
+			LimitPrice:   &bidPrice,
+			TIF:          "DAY",
+			Status:       "NEW",
+			FilledQty:    0,
+			LeavesQty:    bidQty,
+			CreatedAt:    time.Now().Unix(),
 			LastModified: time.Now().Unix(),
 		}
-		
+
 		insertBid(market, bidOrder)
 		market.Orders[bidOrder.ID] = bidOrder
 		log.Printf("🎯 Added initial bid: %s %d @ $%f", market.Symbol, bidQty, bidPrice)
 	}
-	
+
 	for i := 0; i < numAsks; i++ {
 		askPrice := market.LastPrice * (1 + float64(i+1)*0.005) // 0.5%, 1%, 1.5% above
-		askQty := 100 + rand.Intn(900) // 100-1000 shares
-		
+		askQty := 100 + rand.Intn(900)                          // 100-1000 shares
+
 		askOrder := &Order{
-			ID:         fmt.Sprintf("init_ask_%d_%d", time.Now().UnixNano(), i),
-			Symbol:     market.Symbol,
-			Side:       "SELL",
-			OrderType:  "LIMIT",
-			Qty:        askQty,
-			LimitPrice: &askPrice,
-			TIF:        "DAY",
-			Status:     "NEW",
-			FilledQty:  0,
-			LeavesQty:  askQty,
-			CreatedAt:  time.Now().Unix(),
+			ID:           fmt.Sprintf("init_ask_%d_%d", time.Now().UnixNano(), i),
+			Symbol:       market.Symbol,
+			Side:         "SELL",
+			OrderType:    "LIMIT",
+			Qty:          askQty,
+			LimitPrice:   &askPrice,
+			TIF:          "DAY",
+			Status:       "NEW",
+			FilledQty:    0,
+			LeavesQty:    askQty,
+			CreatedAt:    time.Now().Unix(),
 			LastModified: time.Now().Unix(),
 		}
-		
+
 		insertAsk(market, askOrder)
 		market.Orders[askOrder.ID] = askOrder
 		log.Printf("🎯 Added initial ask: %s %d @ $%f", market.Symbol, askQty, askPrice)
@@ -731,6 +839,9 @@ func sendExecutionCallback(order Order) {
 		"status":   order.Status,
 		"message":  "",
 	}
+	if order.GroupID != "" {
+		execReport["group_id"] = order.GroupID
+	}
 
 	if len(order.ExecutionLog) > 0 {
 		lastExec := order.ExecutionLog[len(order.ExecutionLog)-1]
@@ -774,27 +885,33 @@ func simulateMarketMaking(symbol string, market *Market) {
 	market.mu.Lock()
 	defer market.mu.Unlock()
 
+	if market.MakerSource == "xmaker" {
+		// An xmaker subsystem already quotes this market off the source
+		// venue's mid price; don't fight it with random simulated quotes.
+		return
+	}
+
 	// Add some random market making orders
 	if rand.Float64() < 0.3 { // 30% chance
 		// Add a bid
 		bidPrice := market.LastPrice * (1 - rand.Float64()*0.01) // 0-1% below last price
-		bidQty := 100 + rand.Intn(900) // 100-1000 shares
-		
+		bidQty := 100 + rand.Intn(900)                           // 100-1000 shares
+
 		bidOrder := &Order{
-			ID:         fmt.Sprintf("mm_bid_%d", time.Now().UnixNano()),
-			Symbol:     symbol,
-			Side:       "BUY",
-			OrderType:  "LIMIT",
-			Qty:        bidQty,
-			LimitPrice: &bidPrice,
-			TIF:        "DAY",
-			Status:     "NEW",
-			FilledQty:  0,
-			LeavesQty:  bidQty,
-			CreatedAt:  time.Now().Unix(),
+			ID:           fmt.Sprintf("mm_bid_%d", time.Now().UnixNano()),
+			Symbol:       symbol,
+			Side:         "BUY",
+			OrderType:    "LIMIT",
+			Qty:          bidQty,
+			LimitPrice:   &bidPrice,
+			TIF:          "DAY",
+			Status:       "NEW",
+			FilledQty:    0,
+			LeavesQty:    bidQty,
+			CreatedAt:    time.Now().Unix(),
 			LastModified: time.Now().Unix(),
 		}
-		
+
 		insertBid(market, bidOrder)
 		market.Orders[bidOrder.ID] = bidOrder
 		log.Printf("📈 Added market making bid: %s %d @ $%f", symbol, bidQty, bidPrice)
@@ -803,23 +920,23 @@ func simulateMarketMaking(symbol string, market *Market) {
 	if rand.Float64() < 0.3 { // 30% chance
 		// Add an ask
 		askPrice := market.LastPrice * (1 + rand.Float64()*0.01) // 0-1% above last price
-		askQty := 100 + rand.Intn(900) // 100-1000 shares
-		
+		askQty := 100 + rand.Intn(900)                           // 100-1000 shares
+
 		askOrder := &Order{
-			ID:         fmt.Sprintf("mm_ask_%d", time.Now().UnixNano()),
-			Symbol:     symbol,
-			Side:       "SELL",
-			OrderType:  "LIMIT",
-			Qty:        askQty,
-			LimitPrice: &askPrice,
-			TIF:        "DAY",
-			Status:     "NEW",
-			FilledQty:  0,
-			LeavesQty:  askQty,
-			CreatedAt:  time.Now().Unix(),
+			ID:           fmt.Sprintf("mm_ask_%d", time.Now().UnixNano()),
+			Symbol:       symbol,
+			Side:         "SELL",
+			OrderType:    "LIMIT",
+			Qty:          askQty,
+			LimitPrice:   &askPrice,
+			TIF:          "DAY",
+			Status:       "NEW",
+			FilledQty:    0,
+			LeavesQty:    askQty,
+			CreatedAt:    time.Now().Unix(),
 			LastModified: time.Now().Unix(),
 		}
-		
+
 		insertAsk(market, askOrder)
 		market.Orders[askOrder.ID] = askOrder
 		log.Printf("📉 Added market making ask: %s %d @ $%f", symbol, askQty, askPrice)
@@ -856,24 +973,33 @@ func checkStopOrders(symbol string, market *Market) {
 			continue
 		}
 
+		if isKlineTriggerSource(order.TriggerSource) {
+			ensureKlineBucket(market.Symbol, order.Interval, market.LastPrice)
+		}
+		price, ok := resolveTriggerPrice(market, order)
+		if !ok {
+			// KLINE_* source with no closed bar yet - wait for the next one.
+			continue
+		}
+
 		var shouldTrigger bool
 		var triggerReason string
 
 		switch order.OrderType {
 		case "STOP":
-			shouldTrigger, triggerReason = checkStopOrderTrigger(order, market.LastPrice)
+			shouldTrigger, triggerReason = checkStopOrderTrigger(order, price)
 		case "STOP_LIMIT":
-			shouldTrigger, triggerReason = checkStopLimitOrderTrigger(order, market.LastPrice)
+			shouldTrigger, triggerReason = checkStopLimitOrderTrigger(order, price)
 		case "TRAILING_STOP":
-			shouldTrigger, triggerReason = checkTrailingStopOrderTrigger(order, market.LastPrice)
+			shouldTrigger, triggerReason = checkTrailingStopOrderTrigger(order, price)
 		case "TRAILING_STOP_LIMIT":
-			shouldTrigger, triggerReason = checkTrailingStopLimitOrderTrigger(order, market.LastPrice)
+			shouldTrigger, triggerReason = checkTrailingStopLimitOrderTrigger(order, price)
 		}
 
 		if shouldTrigger {
-			log.Printf("Stop order triggered: %s %s %s at price %.2f - %s", 
+			log.Printf("Stop order triggered: %s %s %s at price %.2f - %s",
 				order.Symbol, order.Side, order.OrderType, market.LastPrice, triggerReason)
-			
+
 			// Process the triggered order
 			processTriggeredOrder(market, order)
 		}
@@ -909,56 +1035,55 @@ func checkStopLimitOrderTrigger(order *Order, currentPrice float64) (bool, strin
 }
 
 func checkTrailingStopOrderTrigger(order *Order, currentPrice float64) (bool, string) {
-	if order.TrailingPercent == nil || order.StopPrice == nil {
+	if order.TrailingPercent == nil && order.TrailingAmount == nil {
 		return false, "missing trailing parameters"
 	}
 
-	trailingAmount := currentPrice * (*order.TrailingPercent / 100)
-	var stopPrice float64
-
-	if order.Side == "BUY" {
-		stopPrice = currentPrice - trailingAmount
-		if currentPrice <= stopPrice {
-			return true, fmt.Sprintf("BUY trailing stop triggered: current price %.2f <= trailing stop %.2f", currentPrice, stopPrice)
-		}
-	} else {
-		stopPrice = currentPrice + trailingAmount
-		if currentPrice >= stopPrice {
-			return true, fmt.Sprintf("SELL trailing stop triggered: current price %.2f >= trailing stop %.2f", currentPrice, stopPrice)
-		}
+	checkActivation(order, currentPrice)
+	if isDormant(order) {
+		return false, "pre-activation: trailing stop is dormant"
 	}
 
-	// Update the trailing stop price
+	initTrailingAnchor(order, currentPrice)
+	updateTrailingAnchor(order, currentPrice)
+	stopPrice := trailingStopPrice(order)
 	order.StopPrice = &stopPrice
+
+	if order.Side == "BUY" && currentPrice >= stopPrice {
+		return true, fmt.Sprintf("BUY trailing stop triggered: current price %.2f >= trailing stop %.2f", currentPrice, stopPrice)
+	} else if order.Side == "SELL" && currentPrice <= stopPrice {
+		return true, fmt.Sprintf("SELL trailing stop triggered: current price %.2f <= trailing stop %.2f", currentPrice, stopPrice)
+	}
+
 	return false, ""
 }
 
 func checkTrailingStopLimitOrderTrigger(order *Order, currentPrice float64) (bool, string) {
-	if order.TrailingPercent == nil || order.StopPrice == nil {
+	if order.TrailingPercent == nil && order.TrailingAmount == nil {
 		return false, "missing trailing parameters"
 	}
 
-	trailingAmount := currentPrice * (*order.TrailingPercent / 100)
-	var stopPrice float64
-
-	if order.Side == "BUY" {
-		stopPrice = currentPrice - trailingAmount
-		if currentPrice <= stopPrice {
-			return true, fmt.Sprintf("BUY trailing stop-limit triggered: current price %.2f <= trailing stop %.2f", currentPrice, stopPrice)
-		}
-	} else {
-		stopPrice = currentPrice + trailingAmount
-		if currentPrice >= stopPrice {
-			return true, fmt.Sprintf("SELL trailing stop-limit triggered: current price %.2f >= trailing stop %.2f", currentPrice, stopPrice)
-		}
+	checkActivation(order, currentPrice)
+	if isDormant(order) {
+		return false, "pre-activation: trailing stop is dormant"
 	}
 
-	// Update the trailing stop price
+	initTrailingAnchor(order, currentPrice)
+	updateTrailingAnchor(order, currentPrice)
+	stopPrice := trailingStopPrice(order)
 	order.StopPrice = &stopPrice
+
+	if order.Side == "BUY" && currentPrice >= stopPrice {
+		return true, fmt.Sprintf("BUY trailing stop-limit triggered: current price %.2f >= trailing stop %.2f", currentPrice, stopPrice)
+	} else if order.Side == "SELL" && currentPrice <= stopPrice {
+		return true, fmt.Sprintf("SELL trailing stop-limit triggered: current price %.2f <= trailing stop %.2f", currentPrice, stopPrice)
+	}
+
 	return false, ""
 }
 
 func processTriggeredOrder(market *Market, order *Order) {
+	releaseConditionalOrder(order)
 	order.Status = "TRIGGERED"
 	order.LastModified = time.Now().Unix()
 
@@ -979,7 +1104,7 @@ func processTriggeredOrder(market *Market, order *Order) {
 		} else {
 			executeAgainstBids(market, order)
 		}
-		
+
 		if order.LeavesQty > 0 {
 			if order.Side == "BUY" {
 				insertBid(market, order)
@@ -1011,7 +1136,7 @@ func processTriggeredOrder(market *Market, order *Order) {
 		} else {
 			executeAgainstBids(market, order)
 		}
-		
+
 		if order.LeavesQty > 0 {
 			if order.Side == "BUY" {
 				insertBid(market, order)
@@ -1028,6 +1153,10 @@ func processTriggeredOrder(market *Market, order *Order) {
 		}
 	}
 
+	if order.GroupID != "" {
+		settleOCOFill(market, order, order.FilledQty)
+	}
+
 	// Send execution callback
 	go sendExecutionCallback(*order)
 }
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// mdSubscriber is one open WebSocket connection subscribed to a symbol's
+// L2 feed. lastBids/lastAsks track what was last sent so PublishBookUpdate
+// can emit only the levels that changed.
+//
+// Every send to a subscriber is dispatched through that symbol's
+// marketDataQueue, which runs one job at a time per symbol, so sends for a
+// given subscriber can never actually run concurrently. stateMu is
+// nonetheless kept as defense in depth against any future caller that
+// publishes to a subscriber outside the queue - it guards seq, lastBids, and
+// lastAsks from concurrent access, but (unlike the queue) it does not by
+// itself guarantee delivery order.
+type mdSubscriber struct {
+	conn     *websocket.Conn
+	writeMu  sync.Mutex
+	stateMu  sync.Mutex
+	seq      uint64
+	lastBids map[float64]int
+	lastAsks map[float64]int
+}
+
+func (s *mdSubscriber) writeJSON(v interface{}) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteJSON(v)
+}
+
+// MarketDataPublisher fans out snapshot/delta/trade messages to subscribers
+// of each symbol's feed.
+type MarketDataPublisher struct {
+	mu   sync.RWMutex
+	subs map[string]map[*mdSubscriber]struct{}
+}
+
+var mdPublisher = &MarketDataPublisher{
+	subs: make(map[string]map[*mdSubscriber]struct{}),
+}
+
+func (p *MarketDataPublisher) subscribe(symbol string, sub *mdSubscriber) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.subs[symbol] == nil {
+		p.subs[symbol] = make(map[*mdSubscriber]struct{})
+	}
+	p.subs[symbol][sub] = struct{}{}
+}
+
+func (p *MarketDataPublisher) unsubscribe(symbol string, sub *mdSubscriber) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.subs[symbol], sub)
+}
+
+// marketDataQueue runs each symbol's publish jobs one at a time, in the
+// order they were enqueued. executeAgainstAsks/executeAgainstBids can
+// produce several trades in one market.mu-held pass (a single taker order
+// sweeping multiple price levels); firing an independent goroutine per trade
+// would let them race for delivery order once they got to the subscriber, so
+// instead each symbol gets its own FIFO job queue and a single worker
+// goroutine draining it - the match loop doesn't wait on subscribers
+// (enqueue buffers the job, blocking only once 1024 jobs are already pending
+// for the symbol) without giving up delivery order.
+type marketDataQueue struct {
+	mu   sync.Mutex
+	jobs map[string]chan func()
+}
+
+var mdQueue = &marketDataQueue{jobs: make(map[string]chan func())}
+
+func (q *marketDataQueue) enqueue(symbol string, job func()) {
+	q.mu.Lock()
+	ch, ok := q.jobs[symbol]
+	if !ok {
+		ch = make(chan func(), 1024)
+		q.jobs[symbol] = ch
+		go func() {
+			for j := range ch {
+				j()
+			}
+		}()
+	}
+	q.mu.Unlock()
+	ch <- job
+}
+
+func levelMap(entries []OrderBookEntry) map[float64]int {
+	m := make(map[float64]int, len(entries))
+	for _, e := range entries {
+		m[e.Price] = e.Size
+	}
+	return m
+}
+
+// diffLevels returns only the price levels whose size changed (or that
+// disappeared, reported as size 0) between old and next.
+func diffLevels(old, next map[float64]int) []OrderBookEntry {
+	var out []OrderBookEntry
+	now := time.Now().Unix()
+	for price, size := range next {
+		if old[price] != size {
+			out = append(out, OrderBookEntry{Price: price, Size: size, Venue: "SIMX", Timestamp: now})
+		}
+	}
+	for price := range old {
+		if _, ok := next[price]; !ok {
+			out = append(out, OrderBookEntry{Price: price, Size: 0, Venue: "SIMX", Timestamp: now})
+		}
+	}
+	return out
+}
+
+// publishSnapshotTo sends a full L2 snapshot to a single subscriber and
+// resets its diffing baseline. Used both on initial subscribe and resync.
+func (p *MarketDataPublisher) publishSnapshotTo(sub *mdSubscriber, snap OrderBookSnapshot) {
+	sub.stateMu.Lock()
+	defer sub.stateMu.Unlock()
+
+	sub.seq++
+	msg := map[string]interface{}{
+		"type":       "snapshot",
+		"symbol":     snap.Symbol,
+		"seq":        sub.seq,
+		"bids":       snap.Bids,
+		"asks":       snap.Asks,
+		"last_price": snap.LastPrice,
+	}
+	if err := sub.writeJSON(msg); err != nil {
+		log.Printf("⚠️ marketdata: snapshot write failed for %s: %v", snap.Symbol, err)
+		return
+	}
+	sub.lastBids = levelMap(snap.Bids)
+	sub.lastAsks = levelMap(snap.Asks)
+}
+
+// PublishBookUpdate diffs snap against each subscriber's last-sent state and
+// pushes a delta message containing only the levels that changed.
+func (p *MarketDataPublisher) PublishBookUpdate(symbol string, snap OrderBookSnapshot) {
+	p.mu.RLock()
+	subs := make([]*mdSubscriber, 0, len(p.subs[symbol]))
+	for sub := range p.subs[symbol] {
+		subs = append(subs, sub)
+	}
+	p.mu.RUnlock()
+
+	nextBids := levelMap(snap.Bids)
+	nextAsks := levelMap(snap.Asks)
+
+	for _, sub := range subs {
+		sub.stateMu.Lock()
+		deltaBids := diffLevels(sub.lastBids, nextBids)
+		deltaAsks := diffLevels(sub.lastAsks, nextAsks)
+		if len(deltaBids) == 0 && len(deltaAsks) == 0 {
+			sub.stateMu.Unlock()
+			continue
+		}
+		sub.seq++
+		msg := map[string]interface{}{
+			"type":   "delta",
+			"symbol": symbol,
+			"seq":    sub.seq,
+			"bids":   deltaBids,
+			"asks":   deltaAsks,
+		}
+		if err := sub.writeJSON(msg); err != nil {
+			log.Printf("⚠️ marketdata: delta write failed for %s: %v", symbol, err)
+			sub.stateMu.Unlock()
+			continue
+		}
+		sub.lastBids = nextBids
+		sub.lastAsks = nextAsks
+		sub.stateMu.Unlock()
+	}
+}
+
+// PublishTrade broadcasts a single execution to every subscriber of symbol.
+func (p *MarketDataPublisher) PublishTrade(symbol string, exec Execution) {
+	p.mu.RLock()
+	subs := make([]*mdSubscriber, 0, len(p.subs[symbol]))
+	for sub := range p.subs[symbol] {
+		subs = append(subs, sub)
+	}
+	p.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.stateMu.Lock()
+		sub.seq++
+		msg := map[string]interface{}{
+			"type":      "trade",
+			"symbol":    symbol,
+			"seq":       sub.seq,
+			"price":     exec.Price,
+			"qty":       exec.Qty,
+			"venue":     exec.Venue,
+			"timestamp": exec.Timestamp,
+		}
+		if err := sub.writeJSON(msg); err != nil {
+			log.Printf("⚠️ marketdata: trade write failed for %s: %v", symbol, err)
+		}
+		sub.stateMu.Unlock()
+	}
+}
+
+// publishMarketData is called from within the match loop, after
+// market.LastPrice/Volume have been updated for a fill, while market.mu is
+// still held by the caller. It captures a lock-free snapshot and enqueues the
+// trade/delta onto the symbol's marketDataQueue so slow subscribers can't
+// stall matching, while still landing in the same order the match loop
+// produced them.
+func publishMarketData(market *Market, execution Execution) {
+	recordKlineTrade(market.Symbol, execution.Price, time.Unix(execution.Timestamp, 0))
+	symbol := market.Symbol
+	snap := market.snapshotLocked()
+	mdQueue.enqueue(symbol, func() {
+		mdPublisher.PublishTrade(symbol, execution)
+		mdPublisher.PublishBookUpdate(symbol, snap)
+	})
+}
+
+func handleMarketDataWS(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	symbol := vars["symbol"]
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("⚠️ marketdata: upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := &mdSubscriber{conn: conn}
+	market := exchange.getOrCreateMarket(symbol)
+
+	mdPublisher.subscribe(symbol, sub)
+	defer mdPublisher.unsubscribe(symbol, sub)
+
+	mdQueue.enqueue(symbol, func() {
+		mdPublisher.publishSnapshotTo(sub, market.getOrderBookSnapshot())
+	})
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &req); err != nil {
+			continue
+		}
+		if req.Type == "resync" {
+			mdQueue.enqueue(symbol, func() {
+				mdPublisher.publishSnapshotTo(sub, market.getOrderBookSnapshot())
+			})
+		}
+	}
+}
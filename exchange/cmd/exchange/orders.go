@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// findOrder searches every market for a resting or filled order by ID.
+// Order IDs are unique across the exchange, so a linear scan over markets
+// (not orders) is cheap in practice.
+func (e *Exchange) findOrder(id string) (*Market, *Order, bool) {
+	e.mu.RLock()
+	markets := make([]*Market, 0, len(e.Markets))
+	for _, m := range e.Markets {
+		markets = append(markets, m)
+	}
+	e.mu.RUnlock()
+
+	for _, market := range markets {
+		market.mu.RLock()
+		order, ok := market.Orders[id]
+		market.mu.RUnlock()
+		if ok {
+			return market, order, true
+		}
+	}
+	return nil, nil, false
+}
+
+// sideOf maps an order Side to the book side keys used by the level maps.
+func sideOf(orderSide string) string {
+	if orderSide == "SELL" {
+		return "ASK"
+	}
+	return "BID"
+}
+
+// removeOrderFromBook removes a specific resting order instance from its
+// price level, cleaning up the level if it becomes empty. Unlike
+// cancelTaggedOrders this targets one order, not a MakerSource tag.
+// Callers must hold market.mu.
+func removeOrderFromBook(market *Market, side string, order *Order) {
+	if order.LimitPrice == nil {
+		return
+	}
+	levels := market.BidLevels
+	if side == "ASK" {
+		levels = market.AskLevels
+	}
+	level, ok := levels[*order.LimitPrice]
+	if !ok {
+		return
+	}
+	for i, o := range level.Orders {
+		if o == order {
+			level.Orders = append(level.Orders[:i], level.Orders[i+1:]...)
+			break
+		}
+	}
+	if len(level.Orders) == 0 {
+		delete(levels, *order.LimitPrice)
+	}
+}
+
+func handleCancelOrder(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	market, order, ok := exchange.findOrder(id)
+	if !ok {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+
+	market.mu.Lock()
+	if order.LeavesQty <= 0 || order.Status == "FILLED" || order.Status == "CANCELED" {
+		market.mu.Unlock()
+		http.Error(w, "order is not cancelable in its current state", http.StatusConflict)
+		return
+	}
+
+	removeOrderFromBook(market, sideOf(order.Side), order)
+	order.Status = "CANCELED"
+	order.LeavesQty = 0
+	order.LastModified = time.Now().Unix()
+	if isConditionalOrderType(order.OrderType) {
+		releaseConditionalOrder(order)
+	}
+	if order.GroupID != "" {
+		cancelOCOGroup(market, order.GroupID, order.ID)
+	}
+	market.mu.Unlock()
+
+	go sendExecutionCallback(*order)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
+
+// amendRequest is the PUT /orders/{id} body: only Qty and LimitPrice may be
+// amended via cancel-replace.
+type amendRequest struct {
+	Qty        int      `json:"qty"`
+	LimitPrice *float64 `json:"limit_price,omitempty"`
+}
+
+func handleAmendOrder(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req amendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Qty <= 0 {
+		http.Error(w, "qty must be > 0", http.StatusBadRequest)
+		return
+	}
+
+	market, order, ok := exchange.findOrder(id)
+	if !ok {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+
+	market.mu.Lock()
+	defer market.mu.Unlock()
+
+	if order.LeavesQty <= 0 || order.Status == "FILLED" || order.Status == "CANCELED" {
+		http.Error(w, "order is not amendable in its current state", http.StatusConflict)
+		return
+	}
+
+	side := sideOf(order.Side)
+	priceUnchanged := req.LimitPrice == nil || (order.LimitPrice != nil && *req.LimitPrice == *order.LimitPrice)
+	qtyReduced := req.Qty <= order.Qty
+
+	if priceUnchanged && qtyReduced {
+		// Quantity-only reduction at an unchanged price keeps time priority:
+		// shrink leaves-qty in place rather than re-queuing the order.
+		reduction := order.Qty - req.Qty
+		order.Qty = req.Qty
+		order.LeavesQty -= reduction
+		if order.LeavesQty < 0 {
+			order.LeavesQty = 0
+		}
+	} else {
+		// Price change or quantity increase loses time priority, per
+		// standard exchange cancel-replace semantics: pull the order and
+		// re-queue it at the back of its (possibly new) price level.
+		removeOrderFromBook(market, side, order)
+		order.Qty = req.Qty
+		order.LeavesQty = req.Qty - order.FilledQty
+		if order.LeavesQty < 0 {
+			order.LeavesQty = 0
+		}
+		if req.LimitPrice != nil {
+			order.LimitPrice = req.LimitPrice
+		}
+		if order.LeavesQty > 0 {
+			if order.Side == "BUY" {
+				insertBid(market, order)
+			} else {
+				insertAsk(market, order)
+			}
+		}
+	}
+
+	if order.LeavesQty <= 0 {
+		order.Status = "FILLED"
+	} else if order.FilledQty > 0 {
+		order.Status = "PARTIAL"
+	} else {
+		order.Status = "NEW"
+	}
+	order.LastModified = time.Now().Unix()
+
+	go sendExecutionCallback(*order)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
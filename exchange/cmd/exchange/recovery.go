@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// virtualOrderSnapshotPath is where startVirtualOrderRecovery persists
+// in-flight virtual conditional orders so they survive a process restart.
+const virtualOrderSnapshotPath = "virtual_orders_snapshot.json"
+
+// virtualOrderSnapshot is one entry in the on-disk snapshot: the symbol is
+// kept alongside the order since Order itself doesn't identify which
+// market's book it belongs to.
+type virtualOrderSnapshot struct {
+	Symbol string `json:"symbol"`
+	Order  Order  `json:"order"`
+}
+
+// startVirtualOrderRecovery periodically snapshots every PENDING virtual
+// conditional order to disk. Virtual orders never touch the conditional
+// order store or the balance ledger, so this is their only recovery path.
+func startVirtualOrderRecovery() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		snapshotVirtualOrders()
+	}
+}
+
+func snapshotVirtualOrders() {
+	exchange.mu.RLock()
+	var snap []virtualOrderSnapshot
+	for symbol, market := range exchange.Markets {
+		market.mu.RLock()
+		for _, order := range market.Orders {
+			if order.Virtual && order.Status == "PENDING" && isConditionalOrderType(order.OrderType) {
+				snap = append(snap, virtualOrderSnapshot{Symbol: symbol, Order: *order})
+			}
+		}
+		market.mu.RUnlock()
+	}
+	exchange.mu.RUnlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		log.Printf("⚠️ virtual order snapshot: marshal failed: %v", err)
+		return
+	}
+	if err := os.WriteFile(virtualOrderSnapshotPath, data, 0644); err != nil {
+		log.Printf("⚠️ virtual order snapshot: write failed: %v", err)
+	}
+}
+
+// restoreVirtualOrders re-hydrates any virtual orders a prior process
+// persisted, before the exchange starts serving traffic. A missing snapshot
+// file is the common case on a fresh start, not an error.
+func restoreVirtualOrders() {
+	data, err := os.ReadFile(virtualOrderSnapshotPath)
+	if err != nil {
+		return
+	}
+
+	var snap []virtualOrderSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		log.Printf("⚠️ virtual order recovery: unmarshal failed: %v", err)
+		return
+	}
+
+	for _, entry := range snap {
+		order := entry.Order
+		market := exchange.getOrCreateMarket(entry.Symbol)
+		market.mu.Lock()
+		market.Orders[order.ID] = &order
+		market.mu.Unlock()
+	}
+	if len(snap) > 0 {
+		log.Printf("🔁 recovered %d virtual conditional order(s) from %s", len(snap), virtualOrderSnapshotPath)
+	}
+}
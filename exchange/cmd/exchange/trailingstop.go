@@ -0,0 +1,111 @@
+package main
+
+import "fmt"
+
+// validateActivationGate rejects a MinProfitPercent gate submitted without
+// the EntryPrice it is computed against. ActivationPrice needs no pairing
+// since it is already an absolute price.
+func validateActivationGate(order *Order) error {
+	if order.MinProfitPercent != nil && order.EntryPrice == nil {
+		return fmt.Errorf("entry_price is required when min_profit_percent is set")
+	}
+	return nil
+}
+
+// isActivationGated reports whether an order must cross an activation
+// threshold (bbgo-style MinProfit) before its trail is allowed to arm.
+func isActivationGated(order *Order) bool {
+	return order.ActivationPrice != nil || order.MinProfitPercent != nil
+}
+
+// activationThreshold resolves the absolute price that must be crossed in
+// the favorable direction before the trail arms, preferring an explicit
+// ActivationPrice over a MinProfitPercent computed off EntryPrice.
+func activationThreshold(order *Order) (float64, bool) {
+	if order.ActivationPrice != nil {
+		return *order.ActivationPrice, true
+	}
+	if order.MinProfitPercent != nil && order.EntryPrice != nil {
+		if order.Side == "SELL" {
+			return *order.EntryPrice * (1 + *order.MinProfitPercent/100), true
+		}
+		return *order.EntryPrice * (1 - *order.MinProfitPercent/100), true
+	}
+	return 0, false
+}
+
+// checkActivation arms a gated order once price has moved past its
+// activation threshold in the favorable direction. No-op once armed, or for
+// orders that carry no activation gate at all.
+func checkActivation(order *Order, currentPrice float64) {
+	if order.Activated || !isActivationGated(order) {
+		return
+	}
+	threshold, ok := activationThreshold(order)
+	if !ok {
+		return
+	}
+	if order.Side == "SELL" && currentPrice >= threshold {
+		order.Activated = true
+	} else if order.Side == "BUY" && currentPrice <= threshold {
+		order.Activated = true
+	}
+}
+
+// isDormant reports whether a gated order is still waiting to cross its
+// activation threshold. A dormant order's anchor must not move and it can
+// never trigger.
+func isDormant(order *Order) bool {
+	return isActivationGated(order) && !order.Activated
+}
+
+// initTrailingAnchor seeds an order's high/low water-mark the first time it
+// is evaluated post-activation, from the reference (last trade) price. It is
+// a no-op once the anchor has already been set.
+func initTrailingAnchor(order *Order, referencePrice float64) {
+	anchor := referencePrice
+
+	if order.Side == "SELL" {
+		if order.HighestPrice == nil {
+			order.HighestPrice = &anchor
+		}
+	} else {
+		if order.LowestPrice == nil {
+			order.LowestPrice = &anchor
+		}
+	}
+}
+
+// updateTrailingAnchor advances the persisted high/low water-mark as the
+// market moves favorably. A SELL trailing stop (protecting a long) tracks
+// the peak price; a BUY trailing stop (covering a short) tracks the trough.
+func updateTrailingAnchor(order *Order, currentPrice float64) {
+	if order.Side == "SELL" {
+		if order.HighestPrice == nil || currentPrice > *order.HighestPrice {
+			order.HighestPrice = &currentPrice
+		}
+	} else {
+		if order.LowestPrice == nil || currentPrice < *order.LowestPrice {
+			order.LowestPrice = &currentPrice
+		}
+	}
+}
+
+// trailingStopPrice derives the current trigger price from the persisted
+// anchor, in whichever mode (percent or absolute) the order was submitted
+// with. Callers must have called initTrailingAnchor first.
+func trailingStopPrice(order *Order) float64 {
+	if order.Side == "SELL" {
+		anchor := *order.HighestPrice
+		if order.TrailingAmount != nil {
+			return anchor - *order.TrailingAmount
+		}
+		return anchor * (1 - *order.TrailingPercent/100)
+	}
+
+	anchor := *order.LowestPrice
+	if order.TrailingAmount != nil {
+		return anchor + *order.TrailingAmount
+	}
+	return anchor * (1 + *order.TrailingPercent/100)
+}
@@ -0,0 +1,125 @@
+package main
+
+import "testing"
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+// TestCheckActivation_PreActivation verifies a MinProfitPercent-gated order
+// stays unactivated and dormant until price actually crosses its threshold.
+func TestCheckActivation_PreActivation(t *testing.T) {
+	order := &Order{
+		Side:             "SELL",
+		EntryPrice:       floatPtr(100),
+		MinProfitPercent: floatPtr(2), // arms at 102
+		TrailingPercent:  floatPtr(1),
+	}
+
+	checkActivation(order, 101)
+
+	if order.Activated {
+		t.Fatalf("order should not activate before crossing its MinProfitPercent threshold")
+	}
+	if !isDormant(order) {
+		t.Fatalf("order should still be dormant pre-activation")
+	}
+}
+
+// TestCheckActivation_CrossesThreshold verifies a SELL trail arms once price
+// reaches the MinProfitPercent threshold, and a BUY trail arms on the mirrored
+// (falling) side.
+func TestCheckActivation_CrossesThreshold(t *testing.T) {
+	sell := &Order{
+		Side:             "SELL",
+		EntryPrice:       floatPtr(100),
+		MinProfitPercent: floatPtr(2), // arms at 102
+		TrailingPercent:  floatPtr(1),
+	}
+	checkActivation(sell, 102)
+	if !sell.Activated {
+		t.Fatalf("SELL order should activate once price reaches its threshold")
+	}
+
+	buy := &Order{
+		Side:             "BUY",
+		EntryPrice:       floatPtr(100),
+		MinProfitPercent: floatPtr(2), // arms at 98
+		TrailingPercent:  floatPtr(1),
+	}
+	checkActivation(buy, 98)
+	if !buy.Activated {
+		t.Fatalf("BUY order should activate once price falls to its threshold")
+	}
+}
+
+// TestCheckActivation_ActivatedIsSticky verifies an already-activated order
+// never re-evaluates its threshold, even if price later falls back below it.
+func TestCheckActivation_ActivatedIsSticky(t *testing.T) {
+	order := &Order{
+		Side:             "SELL",
+		EntryPrice:       floatPtr(100),
+		MinProfitPercent: floatPtr(2),
+		TrailingPercent:  floatPtr(1),
+		Activated:        true,
+		HighestPrice:     floatPtr(105),
+	}
+
+	checkActivation(order, 90)
+
+	if !order.Activated {
+		t.Fatalf("an already-activated order must not be deactivated")
+	}
+	if *order.HighestPrice != 105 {
+		t.Fatalf("checkActivation must not touch the anchor once activated, got %v", *order.HighestPrice)
+	}
+}
+
+// TestTrailingStopPrice_PostActivationDrawdown verifies that for an armed
+// SELL trail the high-water-mark holds at its peak as price pulls back, and
+// the trigger price is derived from that peak.
+func TestTrailingStopPrice_PostActivationDrawdown(t *testing.T) {
+	order := &Order{
+		Side:            "SELL",
+		Activated:       true,
+		TrailingPercent: floatPtr(5), // trail 5% below the peak
+	}
+
+	initTrailingAnchor(order, 100)
+	updateTrailingAnchor(order, 110) // price runs up to a new peak
+	updateTrailingAnchor(order, 105) // then pulls back; peak must not drop
+
+	if *order.HighestPrice != 110 {
+		t.Fatalf("high-water-mark should hold at the peak of 110, got %v", *order.HighestPrice)
+	}
+
+	stop := trailingStopPrice(order)
+	want := 110 * 0.95
+	if stop != want {
+		t.Fatalf("trailingStopPrice = %v, want %v", stop, want)
+	}
+}
+
+// TestTrailingStopPrice_AbsoluteTrailingAmount covers the TrailingAmount
+// (absolute offset) mode for a BUY trail covering a short, trailing the
+// trough upward as price drops further.
+func TestTrailingStopPrice_AbsoluteTrailingAmount(t *testing.T) {
+	order := &Order{
+		Side:           "BUY",
+		Activated:      true,
+		TrailingAmount: floatPtr(2),
+	}
+
+	initTrailingAnchor(order, 50)
+	updateTrailingAnchor(order, 45) // new trough
+	updateTrailingAnchor(order, 47) // bounce back up; trough must not rise
+
+	if *order.LowestPrice != 45 {
+		t.Fatalf("low-water-mark should hold at the trough of 45, got %v", *order.LowestPrice)
+	}
+
+	stop := trailingStopPrice(order)
+	if stop != 47 {
+		t.Fatalf("trailingStopPrice = %v, want 47", stop)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// TwapExecution tracks the slicing state for a single TWAP parent order.
+type TwapExecution struct {
+	TargetQuantity int
+	SliceQuantity  int
+	UpdateInterval time.Duration
+	cancelCtx      context.CancelFunc
+	activeOrders   map[string]*Order // side -> currently resting slice order
+}
+
+var (
+	twapMu         sync.Mutex
+	twapExecutions = make(map[string]*TwapExecution) // parent order ID -> execution state
+)
+
+// processTwapOrder validates and registers a TWAP parent order, then starts
+// the background scheduler that slices it across NumOfTicks sub-orders.
+func processTwapOrder(market *Market, order *Order) error {
+	if order.NumOfTicks <= 0 {
+		return fmt.Errorf("num_of_ticks must be > 0 for TWAP orders")
+	}
+	if order.DeadlineTime <= time.Now().Unix() {
+		return fmt.Errorf("deadline_ts must be in the future")
+	}
+
+	sliceQty := order.SliceQty
+	if sliceQty <= 0 {
+		sliceQty = order.Qty / order.NumOfTicks
+		if sliceQty <= 0 {
+			sliceQty = 1
+		}
+	}
+
+	deadline := time.Unix(order.DeadlineTime, 0)
+	interval := time.Until(deadline) / time.Duration(order.NumOfTicks)
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+
+	texec := &TwapExecution{
+		TargetQuantity: order.Qty,
+		SliceQuantity:  sliceQty,
+		UpdateInterval: interval,
+		cancelCtx:      cancel,
+		activeOrders:   make(map[string]*Order),
+	}
+
+	market.mu.Lock()
+	order.Status = "NEW"
+	order.LeavesQty = order.Qty
+	order.LastModified = time.Now().Unix()
+	market.Orders[order.ID] = order
+	market.mu.Unlock()
+
+	twapMu.Lock()
+	twapExecutions[order.ID] = texec
+	twapMu.Unlock()
+
+	go runTwapSchedule(ctx, market, order, texec)
+
+	return nil
+}
+
+// runTwapSchedule fires one slice per tick until the parent is fully filled
+// or the deadline elapses, whichever comes first.
+func runTwapSchedule(ctx context.Context, market *Market, parent *Order, texec *TwapExecution) {
+	ticker := time.NewTicker(texec.UpdateInterval)
+	defer ticker.Stop()
+	defer func() {
+		twapMu.Lock()
+		delete(twapExecutions, parent.ID)
+		twapMu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			finishTwapOrder(market, parent, texec)
+			return
+		case <-ticker.C:
+			if parent.LeavesQty <= 0 {
+				finishTwapOrder(market, parent, texec)
+				return
+			}
+			postTwapSlice(market, parent, texec)
+		}
+	}
+}
+
+// postTwapSlice cancels the previous slice if unfilled, checks the stop-price
+// guard, and posts a fresh slice pegged to the top of book.
+func postTwapSlice(market *Market, parent *Order, texec *TwapExecution) {
+	market.mu.Lock()
+
+	// Cancel the previous slice's resting remainder before posting a new one.
+	// cancelTaggedOrders marks it CANCELED, zeroes LeavesQty and pulls it
+	// from its price level.
+	if prev, ok := texec.activeOrders[parent.Side]; ok && prev.LeavesQty > 0 {
+		if parent.Side == "BUY" {
+			cancelTaggedOrders(market, "BID", prev.MakerSource)
+		} else {
+			cancelTaggedOrders(market, "ASK", prev.MakerSource)
+		}
+	}
+
+	// Stop-price guard: halt slicing if the market has moved past it.
+	if parent.StopPrice != nil {
+		if (parent.Side == "BUY" && market.LastPrice > *parent.StopPrice) ||
+			(parent.Side == "SELL" && market.LastPrice < *parent.StopPrice) {
+			market.mu.Unlock()
+			log.Printf("⏹️ TWAP %s halted: market price %.2f passed stop guard %.2f", parent.ID, market.LastPrice, *parent.StopPrice)
+			return
+		}
+	}
+
+	// Peg to the top of book on the target side.
+	var pegPrice float64
+	if parent.Side == "BUY" {
+		level := market.bestBidLevel()
+		if level == nil {
+			market.mu.Unlock()
+			return
+		}
+		pegPrice = level.Price
+	} else {
+		level := market.bestAskLevel()
+		if level == nil {
+			market.mu.Unlock()
+			return
+		}
+		pegPrice = level.Price
+	}
+
+	sliceQty := texec.SliceQuantity
+	if sliceQty > parent.LeavesQty {
+		sliceQty = parent.LeavesQty
+	}
+
+	slice := &Order{
+		ID:           fmt.Sprintf("%s_slice_%d", parent.ID, time.Now().UnixNano()),
+		Symbol:       parent.Symbol,
+		Side:         parent.Side,
+		OrderType:    "LIMIT",
+		Qty:          sliceQty,
+		LimitPrice:   &pegPrice,
+		TIF:          "DAY",
+		Status:       "NEW",
+		LeavesQty:    sliceQty,
+		CreatedAt:    time.Now().Unix(),
+		LastModified: time.Now().Unix(),
+		MakerSource:  "twap:" + parent.ID,
+	}
+
+	if parent.Side == "BUY" {
+		executeAgainstAsks(market, slice)
+		if slice.LeavesQty > 0 {
+			insertBid(market, slice)
+		}
+	} else {
+		executeAgainstBids(market, slice)
+		if slice.LeavesQty > 0 {
+			insertAsk(market, slice)
+		}
+	}
+	market.Orders[slice.ID] = slice
+	texec.activeOrders[parent.Side] = slice
+
+	filled := sliceQty - slice.LeavesQty
+	parent.FilledQty += filled
+	parent.LeavesQty -= filled
+	parent.ExecutionLog = append(parent.ExecutionLog, slice.ExecutionLog...)
+	if filled > 0 && slice.AvgPrice != nil {
+		// Quantity-weighted average across every slice filled so far, same
+		// as executeAgainstAsks/executeAgainstBids use for a single order.
+		if parent.AvgPrice == nil {
+			parent.AvgPrice = slice.AvgPrice
+		} else {
+			totalValue := float64(parent.FilledQty-filled)**parent.AvgPrice + float64(filled)**slice.AvgPrice
+			newAvg := totalValue / float64(parent.FilledQty)
+			parent.AvgPrice = &newAvg
+		}
+		parent.Status = "PARTIAL"
+	}
+	parent.LastModified = time.Now().Unix()
+
+	market.mu.Unlock()
+
+	if filled > 0 {
+		go sendExecutionCallback(*parent)
+	}
+}
+
+// finishTwapOrder marks the parent complete, canceling any unfilled
+// remainder once the deadline has elapsed.
+func finishTwapOrder(market *Market, parent *Order, texec *TwapExecution) {
+	market.mu.Lock()
+	if prev, ok := texec.activeOrders[parent.Side]; ok && prev.LeavesQty > 0 {
+		prev.Status = "CANCELED"
+		prev.LeavesQty = 0
+		prev.LastModified = time.Now().Unix()
+	}
+	if parent.LeavesQty <= 0 {
+		parent.Status = "FILLED"
+	} else {
+		parent.Status = "CANCELED"
+	}
+	parent.LastModified = time.Now().Unix()
+	market.mu.Unlock()
+
+	go sendExecutionCallback(*parent)
+}
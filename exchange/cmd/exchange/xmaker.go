@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// HedgeConfig configures a cross-exchange market-making subsystem ("xmaker")
+// for a single symbol: quote the local SIMX book off a source venue's mid
+// price, then hedge any accumulated inventory back to that source.
+type HedgeConfig struct {
+	SourceURL      string  `json:"source_url"`       // base URL of the reference venue, e.g. http://source:8082
+	MarginBps      float64 `json:"margin_bps"`       // quote margin applied above/below source mid
+	MaxPosition    int     `json:"max_position"`     // absolute inventory cap; at or past it only the inventory-reducing side is quoted
+	QuoteRefreshMs int     `json:"quote_refresh_ms"` // how often quotes are refreshed
+	MinHedgeQty    int     `json:"min_hedge_qty"`    // minimum |position - coveredPosition| before hedging
+}
+
+// XMakerState tracks the running state of the xmaker subsystem for a market.
+// It lives on the Market so it shares the same mu as the rest of the book.
+type XMakerState struct {
+	Config          HedgeConfig
+	CoveredPosition int // inventory already offset against the source venue
+	Position        int // net filled qty against our own xmaker quotes (+buy/-sell)
+	BidOrderID      string
+	AskOrderID      string
+	started         bool
+}
+
+func handleSetXMakerConfig(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	symbol := vars["symbol"]
+
+	var cfg HedgeConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if cfg.SourceURL == "" {
+		http.Error(w, "source_url is required", http.StatusBadRequest)
+		return
+	}
+	if cfg.QuoteRefreshMs <= 0 {
+		cfg.QuoteRefreshMs = 2000
+	}
+	if cfg.MinHedgeQty <= 0 {
+		cfg.MinHedgeQty = 1
+	}
+
+	market := exchange.getOrCreateMarket(symbol)
+
+	market.mu.Lock()
+	if market.XMaker == nil {
+		market.XMaker = &XMakerState{Config: cfg}
+	} else {
+		market.XMaker.Config = cfg
+	}
+	alreadyStarted := market.XMaker.started
+	if !alreadyStarted {
+		market.XMaker.started = true
+	}
+	market.MakerSource = "xmaker"
+	market.mu.Unlock()
+
+	if !alreadyStarted {
+		go runXMaker(symbol, market)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"symbol": symbol,
+		"config": cfg,
+	})
+}
+
+// sourceMidPrice fetches the reference order book from the source venue and
+// returns its mid price.
+func sourceMidPrice(sourceURL, symbol string) (float64, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/order-book/%s", sourceURL, symbol))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var snap OrderBookSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return 0, err
+	}
+	if len(snap.Bids) == 0 || len(snap.Asks) == 0 {
+		if snap.LastPrice != nil {
+			return *snap.LastPrice, nil
+		}
+		return 0, fmt.Errorf("source book for %s has no quotes", symbol)
+	}
+
+	bestBid := snap.Bids[0].Price
+	bestAsk := snap.Asks[0].Price
+	for _, b := range snap.Bids {
+		if b.Price > bestBid {
+			bestBid = b.Price
+		}
+	}
+	for _, a := range snap.Asks {
+		if a.Price < bestAsk {
+			bestAsk = a.Price
+		}
+	}
+	return (bestBid + bestAsk) / 2, nil
+}
+
+// runXMaker is the background loop driving a single market's hedged
+// market-making. It mirrors the source venue's mid price onto the local
+// book and hedges any inventory it accumulates back to the source.
+func runXMaker(symbol string, market *Market) {
+	for {
+		market.mu.RLock()
+		cfg := market.XMaker.Config
+		market.mu.RUnlock()
+
+		interval := time.Duration(cfg.QuoteRefreshMs) * time.Millisecond
+		time.Sleep(interval)
+
+		mid, err := sourceMidPrice(cfg.SourceURL, symbol)
+		if err != nil {
+			log.Printf("⚠️ xmaker[%s]: failed to fetch source mid: %v", symbol, err)
+			continue
+		}
+
+		refreshXMakerQuotes(symbol, market, mid)
+		hedgeXMakerPosition(symbol, market)
+	}
+}
+
+// refreshXMakerQuotes cancels the previous xmaker quotes and posts a fresh
+// bid/ask pair priced off the source mid.
+func refreshXMakerQuotes(symbol string, market *Market, sourceMid float64) {
+	market.mu.Lock()
+	defer market.mu.Unlock()
+
+	cfg := market.XMaker.Config
+
+	// Cancel the previous round's xmaker quotes before posting new ones.
+	// simulateMarketMaking posts no new quotes on a market with
+	// market.MakerSource set to "xmaker" (see handleSetXMakerConfig). Only
+	// xmaker-tagged quotes are canceled here: untagged quotes placed by
+	// addInitialMarketMaking, or by simulateMarketMaking before xmaker was
+	// attached, stay on the book.
+	cancelTaggedOrders(market, "BID", "xmaker")
+	cancelTaggedOrders(market, "ASK", "xmaker")
+
+	if market.XMaker.Position >= cfg.MaxPosition {
+		// Long enough already - only quote the ask side.
+	} else {
+		bidPrice := sourceMid * (1 - cfg.MarginBps/10000)
+		bidQty := 100
+		bidOrder := &Order{
+			ID:           fmt.Sprintf("xmaker_bid_%d", time.Now().UnixNano()),
+			Symbol:       symbol,
+			Side:         "BUY",
+			OrderType:    "LIMIT",
+			Qty:          bidQty,
+			LimitPrice:   &bidPrice,
+			TIF:          "DAY",
+			Status:       "NEW",
+			LeavesQty:    bidQty,
+			CreatedAt:    time.Now().Unix(),
+			LastModified: time.Now().Unix(),
+			MakerSource:  "xmaker",
+		}
+		insertBid(market, bidOrder)
+		market.Orders[bidOrder.ID] = bidOrder
+		market.XMaker.BidOrderID = bidOrder.ID
+	}
+
+	if market.XMaker.Position <= -cfg.MaxPosition {
+		// Short enough already - only quote the bid side.
+	} else {
+		askPrice := sourceMid * (1 + cfg.MarginBps/10000)
+		askQty := 100
+		askOrder := &Order{
+			ID:           fmt.Sprintf("xmaker_ask_%d", time.Now().UnixNano()),
+			Symbol:       symbol,
+			Side:         "SELL",
+			OrderType:    "LIMIT",
+			Qty:          askQty,
+			LimitPrice:   &askPrice,
+			TIF:          "DAY",
+			Status:       "NEW",
+			LeavesQty:    askQty,
+			CreatedAt:    time.Now().Unix(),
+			LastModified: time.Now().Unix(),
+			MakerSource:  "xmaker",
+		}
+		insertAsk(market, askOrder)
+		market.Orders[askOrder.ID] = askOrder
+		market.XMaker.AskOrderID = askOrder.ID
+	}
+
+	// Recompute position from our own fills against xmaker-tagged quotes.
+	position := 0
+	for _, o := range market.Orders {
+		if o.MakerSource != "xmaker" || o.FilledQty == 0 {
+			continue
+		}
+		if o.Side == "BUY" {
+			position += o.FilledQty
+		} else {
+			position -= o.FilledQty
+		}
+	}
+	market.XMaker.Position = position
+}
+
+// hedgeXMakerPosition sends an offsetting market order to the source venue
+// whenever our covered position has drifted too far from our actual
+// inventory.
+func hedgeXMakerPosition(symbol string, market *Market) {
+	market.mu.Lock()
+	cfg := market.XMaker.Config
+	position := market.XMaker.Position
+	covered := market.XMaker.CoveredPosition
+	market.mu.Unlock()
+
+	drift := position - covered
+	if drift < 0 {
+		drift = -drift
+	}
+	if drift < cfg.MinHedgeQty {
+		return
+	}
+
+	side := "SELL"
+	qty := position - covered
+	if qty < 0 {
+		side = "BUY"
+		qty = -qty
+	}
+
+	hedgeOrder := Order{
+		ID:        fmt.Sprintf("xmaker_hedge_%d", time.Now().UnixNano()),
+		Symbol:    symbol,
+		Side:      side,
+		OrderType: "MARKET",
+		Qty:       qty,
+	}
+
+	body, _ := json.Marshal(hedgeOrder)
+	resp, err := http.Post(cfg.SourceURL+"/orders", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		log.Printf("⚠️ xmaker[%s]: hedge order failed: %v", symbol, err)
+		return
+	}
+	resp.Body.Close()
+
+	log.Printf("🛡️ xmaker[%s]: hedged %s %d to source venue (position=%d)", symbol, side, qty, position)
+
+	market.mu.Lock()
+	market.XMaker.CoveredPosition = position
+	market.mu.Unlock()
+}